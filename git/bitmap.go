@@ -0,0 +1,403 @@
+package git
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"sort"
+)
+
+var bitmapMagic = [4]byte{'B', 'I', 'T', 'M'}
+
+const bitmapOptFullDAG = 0x1
+
+// bitmap is an uncompressed bitset. Bit i is the i-th object in pack order,
+// which is the order of offsets in the pack.
+type bitmap []uint64
+
+func (b bitmap) get(i int) bool {
+	return i/64 < len(b) && b[i/64]&(1<<uint(i%64)) != 0
+}
+
+func (b *bitmap) set(i int) {
+	for i/64 >= len(*b) {
+		*b = append(*b, 0)
+	}
+	(*b)[i/64] |= 1 << uint(i%64)
+}
+
+func (b *bitmap) or(other bitmap) {
+	for len(*b) < len(other) {
+		*b = append(*b, 0)
+	}
+	for i, w := range other {
+		(*b)[i] |= w
+	}
+}
+
+func (b bitmap) xor(other bitmap) bitmap {
+	out := make(bitmap, len(b))
+	copy(out, b)
+	for len(out) < len(other) {
+		out = append(out, 0)
+	}
+	for i, w := range other {
+		out[i] ^= w
+	}
+	return out
+}
+
+// forEach calls fn with the position of each set bit.
+func (b bitmap) forEach(fn func(i int)) {
+	for i, w := range b {
+		for j := 0; w != 0; j++ {
+			if w&1 != 0 {
+				fn(i*64 + j)
+			}
+			w >>= 1
+		}
+	}
+}
+
+// readEWAH decodes a bitmap compressed in EWAH format used by git, and returns
+// the rest of data.
+func readEWAH(data []byte) (bitmap, []byte, error) {
+	if len(data) < 8 {
+		return nil, nil, ErrUnknownFormat
+	}
+	bits := binary.BigEndian.Uint32(data)
+	words := int(binary.BigEndian.Uint32(data[4:]))
+	data = data[8:]
+	if len(data) < words*8+4 {
+		return nil, nil, ErrUnknownFormat
+	}
+	compressed := data[:words*8]
+	data = data[words*8+4:]
+
+	out := make(bitmap, 0, (bits+63)/64)
+	for len(compressed) > 0 {
+		rlw := binary.BigEndian.Uint64(compressed)
+		compressed = compressed[8:]
+		running := rlw&1 != 0
+		runLen := int(rlw >> 1 & 0xffffffff)
+		literals := int(rlw >> 33)
+		var fill uint64
+		if running {
+			fill = ^uint64(0)
+		}
+		for i := 0; i < runLen; i++ {
+			out = append(out, fill)
+		}
+		if len(compressed) < literals*8 {
+			return nil, nil, ErrUnknownFormat
+		}
+		for i := 0; i < literals; i++ {
+			out = append(out, binary.BigEndian.Uint64(compressed[i*8:]))
+		}
+		compressed = compressed[literals*8:]
+	}
+	return out, data, nil
+}
+
+type PackBitmapHeader struct {
+	Magic    [4]byte
+	Version  uint16
+	Flags    uint16
+	Entries  uint32
+	Checksum SHA1
+}
+
+// PackBitmap is a reachability bitmap index of a pack. For selected commits,
+// it has bitmaps of all objects reachable from them.
+type PackBitmap struct {
+	PackBitmapHeader
+	Commits bitmap
+	Trees   bitmap
+	Blobs   bitmap
+	Tags    bitmap
+	entries map[SHA1]bitmap
+	// order is object ids in pack order, which bit positions refer.
+	order    []SHA1
+	position map[SHA1]int
+}
+
+// Bitmap loads the reachability bitmap of the pack from the .bitmap file next
+// to the pack. The loaded bitmap is cached.
+func (p *Pack) Bitmap() (*PackBitmap, error) {
+	if p.bitmap == nil && p.bitmapErr == nil {
+		p.bitmap, p.bitmapErr = openPackBitmap(p.basePath()+".bitmap", p.idx)
+	}
+	return p.bitmap, p.bitmapErr
+}
+
+func openPackBitmap(path string, idx PackIndex) (*PackBitmap, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = verifyTrailingChecksum(b, idx.PackChecksum().Algo()); err != nil {
+		return nil, err
+	}
+	bm := new(PackBitmap)
+	if err = bm.parse(b, idx); err != nil {
+		return nil, err
+	}
+	return bm, nil
+}
+
+func (bm *PackBitmap) parse(data []byte, idx PackIndex) (err error) {
+	h := idx.PackChecksum().Algo()
+	headerLen := 12 + h.Size
+	if len(data) < headerLen {
+		return ErrUnknownFormat
+	}
+	copy(bm.Magic[:], data)
+	bm.Version = binary.BigEndian.Uint16(data[4:])
+	bm.Flags = binary.BigEndian.Uint16(data[6:])
+	bm.Entries = binary.BigEndian.Uint32(data[8:])
+	bm.Checksum = h.FromBytes(data[12:headerLen])
+	if bm.Magic != bitmapMagic || bm.Version != 1 || bm.Flags&bitmapOptFullDAG == 0 {
+		return ErrUnknownFormat
+	}
+	if bm.Checksum != idx.PackChecksum() {
+		return ErrChecksum
+	}
+	data = data[headerLen:]
+
+	for _, b := range []*bitmap{&bm.Commits, &bm.Trees, &bm.Blobs, &bm.Tags} {
+		if *b, data, err = readEWAH(data); err != nil {
+			return
+		}
+	}
+
+	bm.entries = make(map[SHA1]bitmap, bm.Entries)
+	resolved := make([]bitmap, bm.Entries)
+	for i := 0; i < int(bm.Entries); i++ {
+		if len(data) < 6 {
+			return ErrUnknownFormat
+		}
+		pos := int(binary.BigEndian.Uint32(data))
+		xorOffset := int(data[4])
+		data = data[6:]
+		if pos >= idx.Len() || xorOffset > i {
+			return ErrUnknownFormat
+		}
+		var b bitmap
+		if b, data, err = readEWAH(data); err != nil {
+			return
+		}
+		if xorOffset > 0 {
+			b = b.xor(resolved[i-xorOffset])
+		}
+		resolved[i] = b
+		bm.entries[idx.EntryAt(pos).ID] = b
+	}
+
+	bm.order = packOrder(idx)
+	bm.position = make(map[SHA1]int, len(bm.order))
+	for i, id := range bm.order {
+		bm.position[id] = i
+	}
+	return nil
+}
+
+// Has reports whether the commit has a bitmap.
+func (bm *PackBitmap) Has(id SHA1) bool {
+	_, ok := bm.entries[id]
+	return ok
+}
+
+// Reachable returns ids of objects reachable from the commit, or nil if the
+// commit has no bitmap.
+func (bm *PackBitmap) Reachable(id SHA1) []SHA1 {
+	b, ok := bm.entries[id]
+	if !ok {
+		return nil
+	}
+	return bm.objects(b)
+}
+
+func (bm *PackBitmap) objects(b bitmap) []SHA1 {
+	var ids []SHA1
+	b.forEach(func(i int) {
+		if i < len(bm.order) {
+			ids = append(ids, bm.order[i])
+		}
+	})
+	return ids
+}
+
+// packOrder returns object ids sorted by offsets in the pack.
+func packOrder(idx PackIndex) []SHA1 {
+	entries := make([]*PackIndexEntry, idx.Len())
+	for i := range entries {
+		entries[i] = idx.EntryAt(i)
+	}
+	sort.Sort(byOffset(entries))
+	ids := make([]SHA1, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	return ids
+}
+
+type byOffset []*PackIndexEntry
+
+func (z byOffset) Len() int           { return len(z) }
+func (z byOffset) Swap(i, j int)      { z[i], z[j] = z[j], z[i] }
+func (z byOffset) Less(i, j int) bool { return z[i].Offset < z[j].Offset }
+
+// ReachableObjects returns ids of all objects reachable from tips. A bitmap in
+// the repository or its alternates is used if available, so that only objects
+// not covered by the bitmap are walked. Blobs are never read. In a partial
+// clone, ids of missing objects referred from promisor packs are also returned
+// but they're not fetched.
+func (r *Repository) ReachableObjects(tips []SHA1) ([]SHA1, error) {
+	bm, err := r.packBitmap()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		covered bitmap
+		extra   []SHA1
+		seen    = make(map[SHA1]bool)
+	)
+	// visit marks id as visited and reports whether it's visited first time.
+	visit := func(id SHA1) bool {
+		if bm != nil {
+			if pos, ok := bm.position[id]; ok {
+				if covered.get(pos) {
+					return false
+				}
+				covered.set(pos)
+				return true
+			}
+		}
+		if seen[id] {
+			return false
+		}
+		seen[id] = true
+		extra = append(extra, id)
+		return true
+	}
+
+	err = r.walkObjects(tips, func(id SHA1) (bool, error) {
+		if bm != nil {
+			if b, ok := bm.entries[id]; ok {
+				covered.or(b)
+				return false, nil
+			}
+		}
+		return visit(id), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []SHA1
+	if bm != nil {
+		ids = bm.objects(covered)
+	}
+	return append(ids, extra...), nil
+}
+
+// packBitmap returns the first bitmap found in packs of the repository and its
+// alternates, or nil if there is none.
+func (r *Repository) packBitmap() (*PackBitmap, error) {
+	store, ok := r.store.(*fsObjectStore)
+	if !ok {
+		return nil, nil
+	}
+	if err := store.open(); err != nil {
+		return nil, err
+	}
+	for _, dir := range store.dirs {
+		for _, pack := range dir.packs {
+			if bm, err := pack.Bitmap(); err == nil {
+				return bm, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// walkObjects walks objects reachable from tips. fn is called for each object
+// before visiting it, and its children are walked only if fn returns true.
+// Types of objects in trees are known from their modes, so blobs are passed to
+// fn without reading them. Parents of shallow commits are not walked. In a
+// partial clone, missing objects referred from promisor packs are not walked
+// nor fetched, and other missing objects are an error.
+func (r *Repository) walkObjects(tips []SHA1, fn func(id SHA1) (bool, error)) error {
+	var promisor map[SHA1]bool
+	if r.IsPartialClone() {
+		promisor = r.promisorObjects()
+	}
+	stack := make([]walkItem, len(tips))
+	for i, id := range tips {
+		stack[i] = walkItem{id: id}
+	}
+	for len(stack) > 0 {
+		item := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		ok, err := fn(item.id)
+		if err != nil {
+			return err
+		}
+		if !ok || item.typ == "blob" {
+			continue
+		}
+		if promisor != nil && !r.store.Has(item.id) && r.fetched[item.id] == nil {
+			if item.promised {
+				continue
+			}
+			return ErrObjectNotFound
+		}
+		promised := promisor[item.id]
+
+		typ := item.typ
+		if typ == "" {
+			if typ, _, err = r.Stat(item.id); err != nil {
+				return err
+			}
+		}
+		if typ == "commit" {
+			tree, parents, err := r.commitLinks(item.id)
+			if err != nil {
+				return err
+			}
+			for _, parent := range parents {
+				stack = append(stack, walkItem{parent, "commit", promised})
+			}
+			stack = append(stack, walkItem{tree, "tree", promised})
+			continue
+		}
+
+		obj, err := r.Object(item.id)
+		if err != nil {
+			return err
+		}
+		switch typed := obj.(type) {
+		case *Tree:
+			for _, entry := range typed.Entries {
+				switch entry.Mode {
+				case ModeGitlink:
+				case ModeTree:
+					stack = append(stack, walkItem{entry.Object.SHA1(), "tree", promised})
+				default:
+					stack = append(stack, walkItem{entry.Object.SHA1(), "blob", promised})
+				}
+			}
+		case *Tag:
+			stack = append(stack, walkItem{typed.Object.SHA1(), "", promised})
+		}
+	}
+	return nil
+}
+
+// walkItem is an object to be walked. typ is empty if the type is unknown, and
+// promised is true if it's referred from a promisor pack.
+type walkItem struct {
+	id       SHA1
+	typ      string
+	promised bool
+}
@@ -0,0 +1,181 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// encodeEWAH encodes b as a single run of literal words.
+func encodeEWAH(b bitmap) []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint32(len(b)*64))
+	binary.Write(buf, binary.BigEndian, uint32(len(b)+1))
+	binary.Write(buf, binary.BigEndian, uint64(len(b))<<33)
+	binary.Write(buf, binary.BigEndian, []uint64(b))
+	binary.Write(buf, binary.BigEndian, uint32(0))
+	return buf.Bytes()
+}
+
+func TestReadEWAH(t *testing.T) {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, uint32(64*4))
+	binary.Write(buf, binary.BigEndian, uint32(3))
+	// Two words of ones followed by a literal, then a word of zeros.
+	binary.Write(buf, binary.BigEndian, uint64(1)<<33|2<<1|1)
+	binary.Write(buf, binary.BigEndian, uint64(0x5))
+	binary.Write(buf, binary.BigEndian, uint64(1)<<1)
+	binary.Write(buf, binary.BigEndian, uint32(2))
+	buf.WriteString("rest")
+
+	b, rest, err := readEWAH(buf.Bytes())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := bitmap{^uint64(0), ^uint64(0), 0x5, 0}
+	if len(b) != len(expected) {
+		t.Fatalf("Unexpected bitmap: %x", b)
+	}
+	for i := range b {
+		if b[i] != expected[i] {
+			t.Fatalf("Unexpected bitmap: %x", b)
+		}
+	}
+	if string(rest) != "rest" {
+		t.Errorf("Unexpected rest: %q", rest)
+	}
+	if !b.get(128) || b.get(129) || !b.get(130) {
+		t.Errorf("Unexpected bits in %x", b[2])
+	}
+}
+
+func TestReachableObjects(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	ids := newTestObjects(t, repo, 5)
+	commit := ids[len(ids)-1]
+	walked, err := repo.ReachableObjects([]SHA1{commit})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// 5 blobs, the root tree, the dir tree and the commit.
+	if len(walked) != 8 {
+		t.Fatalf("Unexpected number of objects: %d", len(walked))
+	}
+
+	w := NewPackWriter(repo)
+	w.Add(walked...)
+	path, err := w.Save(filepath.Join(dir, "objects", "pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pack, err := OpenPack(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := packOrder(pack.idx)
+	var all bitmap
+	for i := range order {
+		all.set(i)
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(bitmapMagic[:])
+	binary.Write(buf, binary.BigEndian, []uint16{1, bitmapOptFullDAG})
+	binary.Write(buf, binary.BigEndian, uint32(1))
+	buf.Write(pack.idx.PackChecksum().Bytes())
+	for i := 0; i < 4; i++ {
+		buf.Write(encodeEWAH(nil))
+	}
+	for i := 0; i < pack.idx.Len(); i++ {
+		if pack.idx.EntryAt(i).ID == commit {
+			binary.Write(buf, binary.BigEndian, uint32(i))
+		}
+	}
+	buf.Write([]byte{0, 0})
+	buf.Write(encodeEWAH(all))
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	bitmapPath := path[:len(path)-len(".pack")] + ".bitmap"
+	if err = ioutil.WriteFile(bitmapPath, buf.Bytes(), 0444); err != nil {
+		t.Fatal(err)
+	}
+	pack.Close()
+
+	// A new commit which is not covered by the bitmap.
+	repo, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := repo.Object(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := NewUser("go-git", "go-git@example.com")
+	child := repo.NewCommit(parent.(*Commit).Tree, []*Commit{parent.(*Commit)}, user, user, "child\n")
+	if err = child.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	reachable, err := repo.ReachableObjects([]SHA1{child.SHA1()})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(reachable) != len(walked)+1 {
+		t.Fatalf("Unexpected number of objects: %d", len(reachable))
+	}
+	found := make(map[SHA1]bool)
+	for _, id := range reachable {
+		found[id] = true
+	}
+	for _, id := range append(walked, child.SHA1()) {
+		if !found[id] {
+			t.Errorf("%s: not reachable", id)
+		}
+	}
+	bm, err := repo.store.(*fsObjectStore).dirs[0].packs[0].Bitmap()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bm.Has(commit) || len(bm.Reachable(commit)) != len(walked) {
+		t.Errorf("Bitmap of %s is not loaded", commit)
+	}
+
+	// The bitmap is also used by a repository borrowing the objects.
+	borrower := filepath.Join(dir, "borrower")
+	if _, err = Init(borrower, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	alternates := filepath.Join(borrower, "objects", "info", "alternates")
+	if err = ioutil.WriteFile(alternates, []byte(filepath.Join(dir, "objects")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if repo, err = Open(borrower); err != nil {
+		t.Fatal(err)
+	}
+	if bm, err = repo.packBitmap(); err != nil || bm == nil || !bm.Has(commit) {
+		t.Errorf("Bitmap of alternates is not found: %v", err)
+	}
+}
+
+func TestReachableObjectsWithoutBlobs(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	ids := newTestObjects(t, repo, 2)
+	// Blobs are not read, so a broken blob doesn't matter.
+	h := ids[0].String()
+	path := filepath.Join(dir, "objects", h[:2], h[2:])
+	os.Chmod(path, 0644)
+	if err := ioutil.WriteFile(path, []byte("broken"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	objs, err := repo.ReachableObjects(ids[len(ids)-1:])
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// 2 blobs, the root tree, the dir tree and the commit.
+	if len(objs) != 5 {
+		t.Errorf("Unexpected number of objects: %d", len(objs))
+	}
+}
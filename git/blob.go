@@ -33,6 +33,29 @@ func (b *Blob) Resolved() bool {
 	return b.Data != nil
 }
 
+// Reader returns a reader of the blob content. Unlike Resolve, the content is
+// not loaded into Data unless it's already resolved.
+func (b *Blob) Reader() (*ObjectReader, error) {
+	if b.Data != nil {
+		return &ObjectReader{
+			Type:   "blob",
+			Size:   int64(len(b.Data)),
+			r:      bytes.NewReader(b.Data),
+			remain: int64(len(b.Data)),
+			closer: closerFunc(func() error { return nil }),
+		}, nil
+	}
+	o, err := b.repo.ObjectReader(b.id)
+	if err != nil {
+		return nil, err
+	}
+	if o.Type != "blob" {
+		o.Close()
+		return nil, ErrTypeMismatch
+	}
+	return o, nil
+}
+
 func (b *Blob) Write() error {
 	if len(b.Data) > 0 {
 		b.od = bytes.NewReader(b.Data)
@@ -0,0 +1,129 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// chunkID identifies a chunk in the chunk-based file formats used by
+// multi-pack-index and commit-graph.
+type chunkID [4]byte
+
+type chunk struct {
+	id   chunkID
+	data []byte
+}
+
+// readChunks parses the chunk lookup table of n chunks at the head of b. The
+// table is followed by a terminating entry whose offset is the end of the
+// last chunk. Offsets are relative to the beginning of the file, which is
+// base bytes before b.
+func readChunks(b []byte, base int, n int) (map[chunkID][]byte, error) {
+	if len(b) < (n+1)*12 {
+		return nil, ErrUnknownFormat
+	}
+	chunks := make(map[chunkID][]byte)
+	size := int64(base + len(b))
+	for i := 0; i < n; i++ {
+		var id chunkID
+		copy(id[:], b[i*12:])
+		start := int64(binary.BigEndian.Uint64(b[i*12+4:]))
+		end := int64(binary.BigEndian.Uint64(b[(i+1)*12+4:]))
+		if start < int64(base) || start > end || end > size {
+			return nil, ErrUnknownFormat
+		}
+		chunks[id] = b[start-int64(base) : end-int64(base)]
+	}
+	return chunks, nil
+}
+
+// writeChunkFile writes header, the chunk lookup table, chunks and the
+// trailing checksum of them computed by h.
+func writeChunkFile(w io.Writer, header []byte, chunks []chunk, h *HashAlgo) error {
+	hasher := h.New()
+	mw := io.MultiWriter(w, hasher)
+	if _, err := mw.Write(header); err != nil {
+		return err
+	}
+
+	table := new(bytes.Buffer)
+	offset := uint64(len(header) + (len(chunks)+1)*12)
+	for _, c := range chunks {
+		table.Write(c.id[:])
+		binary.Write(table, binary.BigEndian, offset)
+		offset += uint64(len(c.data))
+	}
+	table.Write(make([]byte, 4))
+	binary.Write(table, binary.BigEndian, offset)
+	if _, err := mw.Write(table.Bytes()); err != nil {
+		return err
+	}
+
+	for _, c := range chunks {
+		if _, err := mw.Write(c.data); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(hasher.Sum(nil))
+	return err
+}
+
+// verifyTrailingChecksum checks the checksum computed by h at the end of b and
+// returns b without it.
+func verifyTrailingChecksum(b []byte, h *HashAlgo) ([]byte, error) {
+	if len(b) < h.Size {
+		return nil, ErrUnknownFormat
+	}
+	data, checksum := b[:len(b)-h.Size], b[len(b)-h.Size:]
+	hasher := h.New()
+	hasher.Write(data)
+	if !bytes.Equal(hasher.Sum(nil), checksum) {
+		return nil, ErrChecksum
+	}
+	return data, nil
+}
+
+// readOIDChunks reads the fanout and the sorted object id list which are
+// common in chunk-based files.
+func readOIDChunks(fanout, oids []byte, h *HashAlgo) (f [256]uint32, ids []SHA1, err error) {
+	if len(fanout) != 256*4 {
+		err = ErrUnknownFormat
+		return
+	}
+	for i := range f {
+		f[i] = binary.BigEndian.Uint32(fanout[i*4:])
+		if i > 0 && f[i] < f[i-1] {
+			err = ErrUnknownFormat
+			return
+		}
+	}
+	n := int(f[255])
+	if len(oids) != n*h.Size {
+		err = ErrUnknownFormat
+		return
+	}
+	ids = make([]SHA1, n)
+	for i := range ids {
+		ids[i] = h.FromBytes(oids[i*h.Size:])
+	}
+	return
+}
+
+// oidChunks builds the fanout and the object id list chunks from sorted ids.
+func oidChunks(ids []SHA1) (fanout, oids []byte) {
+	var f [256]uint32
+	for _, id := range ids {
+		f[id.hash[0]]++
+	}
+	fanout = make([]byte, 256*4)
+	var total uint32
+	for i, n := range f {
+		total += n
+		binary.BigEndian.PutUint32(fanout[i*4:], total)
+	}
+	for _, id := range ids {
+		oids = append(oids, id.Bytes()...)
+	}
+	return
+}
@@ -89,6 +89,12 @@ func (c *Commit) IsMerge() bool {
 	return len(c.Parents) != 1
 }
 
+// IsShallow reports whether the history is cut off at the commit by a shallow
+// clone. Parents of such a commit are not in the repository.
+func (c *Commit) IsShallow() bool {
+	return c.repo.isShallowCommit(c.id)
+}
+
 func (c *Commit) Write() error {
 	b := new(bytes.Buffer)
 	fmt.Fprintf(b, "tree %v\n", c.Tree.SHA1())
@@ -0,0 +1,445 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var commitGraphMagic = [4]byte{'C', 'G', 'P', 'H'}
+
+var (
+	commitGraphChunkOIDFanout = chunkID{'O', 'I', 'D', 'F'}
+	commitGraphChunkOIDLookup = chunkID{'O', 'I', 'D', 'L'}
+	commitGraphChunkData      = chunkID{'C', 'D', 'A', 'T'}
+	commitGraphChunkEdges     = chunkID{'E', 'D', 'G', 'E'}
+	commitGraphChunkBase      = chunkID{'B', 'A', 'S', 'E'}
+)
+
+const (
+	commitGraphNoParent      = 0x70000000
+	commitGraphExtraEdges    = 0x80000000
+	commitGraphLastEdge      = 0x80000000
+	commitGraphMaxGeneration = 0x3fffffff
+)
+
+type CommitGraphHeader struct {
+	Magic       [4]byte
+	Version     byte
+	HashVersion byte
+	Chunks      byte
+	BaseGraphs  byte
+}
+
+// CommitGraph is the commit-graph file which stores parents, root tree, commit
+// time and generation number of commits, so that history can be walked
+// without inflating commit objects. A split commit-graph forms a chain where
+// each graph has the preceding one as its base.
+type CommitGraph struct {
+	CommitGraphHeader
+	Fanout  [256]uint32
+	Objects []SHA1
+	hash    *HashAlgo
+	data    []byte
+	edges   []byte
+	base    *CommitGraph
+	// numBase is the number of commits in all base graphs. Positions of
+	// commits in this graph start from it.
+	numBase int
+}
+
+type CommitGraphEntry struct {
+	ID         SHA1
+	Tree       SHA1
+	Parents    []SHA1
+	Generation uint32
+	CommitTime int64
+}
+
+// OpenCommitGraph opens a single commit-graph file.
+func OpenCommitGraph(path string) (*CommitGraph, error) {
+	return openCommitGraph(path, nil)
+}
+
+func openCommitGraph(path string, base *CommitGraph) (*CommitGraph, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	g := &CommitGraph{base: base}
+	if base != nil {
+		g.numBase = base.Len()
+	}
+	err = g.Parse(b)
+	return g, err
+}
+
+func (g *CommitGraph) Parse(b []byte) error {
+	err := binary.Read(bytes.NewReader(b), binary.BigEndian, &g.CommitGraphHeader)
+	if err != nil {
+		return err
+	}
+	if g.Magic != commitGraphMagic || g.Version != 1 {
+		return ErrUnknownFormat
+	}
+	if g.hash, err = hashAlgoByVersion(g.HashVersion); err != nil {
+		return err
+	}
+	if g.base != nil && g.base.hash != g.hash {
+		return ErrUnknownFormat
+	}
+	if b, err = verifyTrailingChecksum(b, g.hash); err != nil {
+		return err
+	}
+	headerLen := binary.Size(g.CommitGraphHeader)
+	chunks, err := readChunks(b[headerLen:], headerLen, int(g.Chunks))
+	if err != nil {
+		return err
+	}
+	if g.Fanout, g.Objects, err = readOIDChunks(chunks[commitGraphChunkOIDFanout], chunks[commitGraphChunkOIDLookup], g.hash); err != nil {
+		return err
+	}
+	g.data = chunks[commitGraphChunkData]
+	g.edges = chunks[commitGraphChunkEdges]
+	if len(g.data) != len(g.Objects)*(g.hash.Size+16) {
+		return ErrUnknownFormat
+	}
+
+	var bases int
+	for base := g.base; base != nil; base = base.base {
+		bases++
+	}
+	if int(g.BaseGraphs) != bases || len(chunks[commitGraphChunkBase]) != bases*g.hash.Size {
+		return ErrUnknownFormat
+	}
+	return nil
+}
+
+// Len returns the number of commits in the graph including its bases.
+func (g *CommitGraph) Len() int {
+	return g.numBase + len(g.Objects)
+}
+
+// Lookup returns the entry of the commit, or nil if the graph doesn't have it.
+func (g *CommitGraph) Lookup(id SHA1) *CommitGraphEntry {
+	for ; g != nil; g = g.base {
+		if x := searchFanout(&g.Fanout, g.Objects, id); x >= 0 {
+			return g.entryAt(x)
+		}
+	}
+	return nil
+}
+
+// EntryAt returns the commit at pos in the whole chain.
+func (g *CommitGraph) EntryAt(pos int) *CommitGraphEntry {
+	g, x := g.locate(pos)
+	if g == nil {
+		return nil
+	}
+	return g.entryAt(x)
+}
+
+func (g *CommitGraph) locate(pos int) (*CommitGraph, int) {
+	for ; g != nil; g = g.base {
+		if pos >= g.numBase {
+			if x := pos - g.numBase; x < len(g.Objects) {
+				return g, x
+			}
+			return nil, 0
+		}
+	}
+	return nil, 0
+}
+
+func (g *CommitGraph) entryAt(x int) *CommitGraphEntry {
+	size := g.hash.Size
+	data := g.data[x*(size+16):]
+	entry := &CommitGraphEntry{
+		ID:   g.Objects[x],
+		Tree: g.hash.FromBytes(data[:size]),
+	}
+	parent1 := binary.BigEndian.Uint32(data[size:])
+	parent2 := binary.BigEndian.Uint32(data[size+4:])
+	high := binary.BigEndian.Uint32(data[size+8:])
+	low := binary.BigEndian.Uint32(data[size+12:])
+	entry.Generation = high >> 2
+	entry.CommitTime = int64(high&0x3)<<32 | int64(low)
+
+	if parent1 != commitGraphNoParent {
+		entry.Parents = append(entry.Parents, g.idAt(int(parent1)))
+	}
+	switch {
+	case parent2 == commitGraphNoParent:
+	case parent2&commitGraphExtraEdges == 0:
+		entry.Parents = append(entry.Parents, g.idAt(int(parent2)))
+	default:
+		for i := int(parent2 &^ commitGraphExtraEdges); (i+1)*4 <= len(g.edges); i++ {
+			edge := binary.BigEndian.Uint32(g.edges[i*4:])
+			entry.Parents = append(entry.Parents, g.idAt(int(edge&^commitGraphLastEdge)))
+			if edge&commitGraphLastEdge != 0 {
+				break
+			}
+		}
+	}
+	return entry
+}
+
+func (g *CommitGraph) idAt(pos int) SHA1 {
+	if g, x := g.locate(pos); g != nil {
+		return g.Objects[x]
+	}
+	return g.hash.Zero()
+}
+
+// CommitGraph loads objects/info/commit-graph, or the split commit-graph chain
+// in objects/info/commit-graphs if the former doesn't exist. The result
+// including an error is cached.
+func (r *Repository) CommitGraph() (*CommitGraph, error) {
+	if r.commitGraph == nil && r.commitGraphErr == nil {
+		info := filepath.Join(r.objectsPath(), "info")
+		g, err := OpenCommitGraph(filepath.Join(info, "commit-graph"))
+		if os.IsNotExist(err) {
+			g, err = openCommitGraphChain(filepath.Join(info, "commit-graphs"))
+		}
+		if err == nil && g.hash != r.hash {
+			err = ErrUnknownFormat
+		}
+		if err != nil {
+			g = nil
+		}
+		r.commitGraph, r.commitGraphErr = g, err
+	}
+	return r.commitGraph, r.commitGraphErr
+}
+
+func openCommitGraphChain(dir string) (*CommitGraph, error) {
+	f, err := os.Open(filepath.Join(dir, "commit-graph-chain"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var g *CommitGraph
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		name := string(bytes.TrimSpace(scan.Bytes()))
+		if name == "" {
+			continue
+		}
+		if g, err = openCommitGraph(filepath.Join(dir, "graph-"+name+".graph"), g); err != nil {
+			return nil, err
+		}
+	}
+	if err = scan.Err(); err != nil {
+		return nil, err
+	}
+	if g == nil {
+		return nil, ErrUnknownFormat
+	}
+	return g, nil
+}
+
+// commitLinks returns the root tree and the parents of the commit. The
+// commit-graph is used if available, otherwise the commit object is parsed. A
+// shallow commit has no parents.
+func (r *Repository) commitLinks(id SHA1) (tree SHA1, parents []SHA1, err error) {
+	shallow := r.isShallowCommit(id)
+	if g, err := r.CommitGraph(); err == nil {
+		if entry := g.Lookup(id); entry != nil {
+			if !shallow {
+				parents = entry.Parents
+			}
+			return entry.Tree, parents, nil
+		}
+	}
+	commit, err := r.Commit(id)
+	if err != nil {
+		return
+	}
+	if !shallow {
+		parents = make([]SHA1, len(commit.Parents))
+		for i, parent := range commit.Parents {
+			parents[i] = parent.SHA1()
+		}
+	}
+	return commit.Tree.SHA1(), parents, nil
+}
+
+// WriteCommitGraph writes objects/info/commit-graph which contains all commits
+// reachable from tips. Annotated tags in tips are peeled. It's not supported in
+// a shallow repository same as git, because the history is incomplete.
+func (r *Repository) WriteCommitGraph(tips []SHA1) error {
+	if _, ok := r.store.(*fsObjectStore); !ok || r.IsShallow() {
+		return ErrNotSupported
+	}
+	commits, err := r.collectCommits(tips)
+	if err != nil {
+		return err
+	}
+	g := newCommitGraphWriter(commits, r.hash)
+
+	info := filepath.Join(r.objectsPath(), "info")
+	if err = os.MkdirAll(info, 0777); err != nil {
+		return err
+	}
+	tmp, err := writeTempFile(info, "tmp_graph_", g.encode)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filepath.Join(info, "commit-graph")); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	r.commitGraph, r.commitGraphErr = nil, nil
+	return nil
+}
+
+// collectCommits parses all commits reachable from tips.
+func (r *Repository) collectCommits(tips []SHA1) (map[SHA1]*Commit, error) {
+	commits := make(map[SHA1]*Commit)
+	var stack []SHA1
+	for _, id := range tips {
+		commit, err := r.NewRef("", id).Commit()
+		if err == ErrTypeMismatch {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		stack = append(stack, commit.SHA1())
+	}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := commits[id]; ok {
+			continue
+		}
+		commit, err := r.Commit(id)
+		if err != nil {
+			return nil, err
+		}
+		commits[id] = commit
+		for _, parent := range commit.Parents {
+			stack = append(stack, parent.SHA1())
+		}
+	}
+	return commits, nil
+}
+
+type commitGraphWriter struct {
+	hash        *HashAlgo
+	ids         []SHA1
+	commits     map[SHA1]*Commit
+	pos         map[SHA1]uint32
+	generations map[SHA1]uint32
+}
+
+func newCommitGraphWriter(commits map[SHA1]*Commit, hash *HashAlgo) *commitGraphWriter {
+	w := &commitGraphWriter{
+		hash:        hash,
+		commits:     commits,
+		pos:         make(map[SHA1]uint32),
+		generations: make(map[SHA1]uint32),
+	}
+	for id := range commits {
+		w.ids = append(w.ids, id)
+	}
+	sort.Sort(bySHA1(w.ids))
+	for i, id := range w.ids {
+		w.pos[id] = uint32(i)
+	}
+	for _, id := range w.ids {
+		w.computeGeneration(id)
+	}
+	return w
+}
+
+// computeGeneration computes topological levels with an explicit stack to
+// walk long histories. A root commit has generation 1.
+func (w *commitGraphWriter) computeGeneration(id SHA1) {
+	stack := []SHA1{id}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		if _, ok := w.generations[id]; ok {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		var gen uint32
+		ready := true
+		for _, parent := range w.commits[id].Parents {
+			pgen, ok := w.generations[parent.SHA1()]
+			if !ok {
+				stack = append(stack, parent.SHA1())
+				ready = false
+			} else if pgen > gen {
+				gen = pgen
+			}
+		}
+		if ready {
+			if gen < commitGraphMaxGeneration {
+				gen++
+			}
+			w.generations[id] = gen
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+func (w *commitGraphWriter) encode(out io.Writer) error {
+	fanout, oids := oidChunks(w.ids)
+	data := new(bytes.Buffer)
+	edges := new(bytes.Buffer)
+	for _, id := range w.ids {
+		commit := w.commits[id]
+		data.Write(commit.Tree.SHA1().Bytes())
+
+		parents := make([]uint32, len(commit.Parents))
+		for i, parent := range commit.Parents {
+			parents[i] = w.pos[parent.SHA1()]
+		}
+		parent1, parent2 := uint32(commitGraphNoParent), uint32(commitGraphNoParent)
+		switch len(parents) {
+		case 0:
+		case 1:
+			parent1 = parents[0]
+		case 2:
+			parent1, parent2 = parents[0], parents[1]
+		default:
+			parent1 = parents[0]
+			parent2 = commitGraphExtraEdges | uint32(edges.Len()/4)
+			for i, pos := range parents[1:] {
+				if i == len(parents)-2 {
+					pos |= commitGraphLastEdge
+				}
+				binary.Write(edges, binary.BigEndian, pos)
+			}
+		}
+		binary.Write(data, binary.BigEndian, parent1)
+		binary.Write(data, binary.BigEndian, parent2)
+
+		time := uint64(commit.Committer.Date.Unix())
+		binary.Write(data, binary.BigEndian, w.generations[id]<<2|uint32(time>>32)&0x3)
+		binary.Write(data, binary.BigEndian, uint32(time))
+	}
+
+	chunks := []chunk{
+		{commitGraphChunkOIDFanout, fanout},
+		{commitGraphChunkOIDLookup, oids},
+		{commitGraphChunkData, data.Bytes()},
+	}
+	if edges.Len() > 0 {
+		chunks = append(chunks, chunk{commitGraphChunkEdges, edges.Bytes()})
+	}
+	header := new(bytes.Buffer)
+	binary.Write(header, binary.BigEndian, &CommitGraphHeader{
+		Magic:       commitGraphMagic,
+		Version:     1,
+		HashVersion: w.hash.version,
+		Chunks:      byte(len(chunks)),
+	})
+	return writeChunkFile(out, header.Bytes(), chunks, w.hash)
+}
@@ -0,0 +1,211 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCommitGraph(t *testing.T) {
+	repo, _ := newTestRepo(t, true)
+	user := NewUser("go-git", "go-git@example.com")
+	root := newTestCommit(t, repo, user, "root")
+	a := newTestCommit(t, repo, user, "a", root)
+	b := newTestCommit(t, repo, user, "b", root)
+	c := newTestCommit(t, repo, user, "c", a)
+	merge := newTestCommit(t, repo, user, "merge", c, a, b)
+
+	if err := repo.WriteCommitGraph([]SHA1{merge.SHA1()}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	g, err := repo.CommitGraph()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := g.Len(); n != 5 {
+		t.Fatalf("Unexpected number of commits: %d", n)
+	}
+	for _, tc := range []struct {
+		commit     *Commit
+		generation uint32
+	}{
+		{root, 1}, {a, 2}, {b, 2}, {c, 3}, {merge, 4},
+	} {
+		entry := g.Lookup(tc.commit.SHA1())
+		if entry == nil {
+			t.Errorf("%s: not found", tc.commit.SHA1())
+			continue
+		}
+		if entry.Tree != tc.commit.Tree.SHA1() || entry.Generation != tc.generation ||
+			entry.CommitTime != user.Date.Unix() || len(entry.Parents) != len(tc.commit.Parents) {
+			t.Errorf("%s: Unexpected entry: %+v", tc.commit.SHA1(), entry)
+			continue
+		}
+		for i, parent := range tc.commit.Parents {
+			if entry.Parents[i] != parent.SHA1() {
+				t.Errorf("%s: Parent mismatch: %s != %s", tc.commit.SHA1(), entry.Parents[i], parent.SHA1())
+			}
+		}
+	}
+}
+
+func TestCommitGraphChain(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	user := NewUser("go-git", "go-git@example.com")
+	root := newTestCommit(t, repo, user, "root")
+	a := newTestCommit(t, repo, user, "a", root)
+	b := newTestCommit(t, repo, user, "b", a)
+	c := newTestCommit(t, repo, user, "c", b)
+	merge := newTestCommit(t, repo, user, "merge", c, a, root)
+	generations := map[SHA1]uint32{
+		root.SHA1(): 1, a.SHA1(): 2, b.SHA1(): 3, c.SHA1(): 4, merge.SHA1(): 5,
+	}
+
+	// The top layer refers to parents in the base layer by positions.
+	graphs := filepath.Join(dir, "objects", "info", "commit-graphs")
+	if err := os.MkdirAll(graphs, 0777); err != nil {
+		t.Fatal(err)
+	}
+	pos := make(map[SHA1]uint32)
+	var names []string
+	var bases []SHA1
+	for _, layer := range [][]*Commit{{root, a}, {b, c, merge}} {
+		commits := make(map[SHA1]*Commit)
+		var ids []SHA1
+		for _, commit := range layer {
+			commits[commit.SHA1()] = commit
+			ids = append(ids, commit.SHA1())
+		}
+		sort.Sort(bySHA1(ids))
+		for _, id := range ids {
+			pos[id] = uint32(len(pos))
+		}
+		data := encodeCommitGraphLayer(ids, commits, pos, generations, bases)
+		checksum := HashSHA1.FromBytes(data[len(data)-HashSHA1.Size:])
+		name := filepath.Join(graphs, "graph-"+checksum.String()+".graph")
+		if err := ioutil.WriteFile(name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, checksum.String())
+		bases = append(bases, checksum)
+	}
+	chain := filepath.Join(graphs, "commit-graph-chain")
+	if err := ioutil.WriteFile(chain, []byte(strings.Join(names, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := repo.CommitGraph()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := g.Len(); n != 5 || g.BaseGraphs != 1 {
+		t.Fatalf("Unexpected graph: %d commits, %d bases", n, g.BaseGraphs)
+	}
+	for _, commit := range []*Commit{root, a, b, c, merge} {
+		entry := g.Lookup(commit.SHA1())
+		if entry == nil {
+			t.Errorf("%s: not found", commit.SHA1())
+			continue
+		}
+		if entry.Tree != commit.Tree.SHA1() || entry.Generation != generations[commit.SHA1()] ||
+			len(entry.Parents) != len(commit.Parents) {
+			t.Errorf("%s: Unexpected entry: %+v", commit.SHA1(), entry)
+			continue
+		}
+		for i, parent := range commit.Parents {
+			if entry.Parents[i] != parent.SHA1() {
+				t.Errorf("%s: Parent mismatch: %s != %s", commit.SHA1(), entry.Parents[i], parent.SHA1())
+			}
+		}
+		if entry := g.EntryAt(int(pos[commit.SHA1()])); entry == nil || entry.ID != commit.SHA1() {
+			t.Errorf("%s: Unexpected entry at %d: %+v", commit.SHA1(), pos[commit.SHA1()], entry)
+		}
+	}
+
+	// The top layer can't be opened without its base.
+	if err = ioutil.WriteFile(chain, []byte(names[1]+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = openCommitGraphChain(graphs); err != ErrUnknownFormat {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func newTestCommit(t *testing.T, repo *Repository, user *User, msg string, parents ...*Commit) *Commit {
+	tree := repo.NewTree()
+	tree.Add("file", repo.NewBlob(bytes.NewReader([]byte(msg))), ModeFile)
+	if err := tree.Write(); err != nil {
+		t.Fatal(err)
+	}
+	commit := repo.NewCommit(tree, parents, user, user, msg)
+	if err := commit.Write(); err != nil {
+		t.Fatal(err)
+	}
+	return commit
+}
+
+// encodeCommitGraphLayer encodes a layer of a split commit-graph. ids must be
+// sorted, pos has positions of commits in the whole chain, and bases are
+// checksums of the preceding layers.
+func encodeCommitGraphLayer(ids []SHA1, commits map[SHA1]*Commit, pos, generations map[SHA1]uint32, bases []SHA1) []byte {
+	fanout, oids := oidChunks(ids)
+	data := new(bytes.Buffer)
+	edges := new(bytes.Buffer)
+	for _, id := range ids {
+		commit := commits[id]
+		data.Write(commit.Tree.SHA1().Bytes())
+		parent1, parent2 := uint32(commitGraphNoParent), uint32(commitGraphNoParent)
+		if len(commit.Parents) > 0 {
+			parent1 = pos[commit.Parents[0].SHA1()]
+		}
+		switch {
+		case len(commit.Parents) == 2:
+			parent2 = pos[commit.Parents[1].SHA1()]
+		case len(commit.Parents) > 2:
+			parent2 = commitGraphExtraEdges | uint32(edges.Len()/4)
+			for i, parent := range commit.Parents[1:] {
+				edge := pos[parent.SHA1()]
+				if i == len(commit.Parents)-2 {
+					edge |= commitGraphLastEdge
+				}
+				binary.Write(edges, binary.BigEndian, edge)
+			}
+		}
+		binary.Write(data, binary.BigEndian, parent1)
+		binary.Write(data, binary.BigEndian, parent2)
+		binary.Write(data, binary.BigEndian, generations[id]<<2)
+		binary.Write(data, binary.BigEndian, uint32(commit.Committer.Date.Unix()))
+	}
+
+	chunks := []chunk{
+		{commitGraphChunkOIDFanout, fanout},
+		{commitGraphChunkOIDLookup, oids},
+		{commitGraphChunkData, data.Bytes()},
+	}
+	if edges.Len() > 0 {
+		chunks = append(chunks, chunk{commitGraphChunkEdges, edges.Bytes()})
+	}
+	if len(bases) > 0 {
+		var base []byte
+		for _, id := range bases {
+			base = append(base, id.Bytes()...)
+		}
+		chunks = append(chunks, chunk{commitGraphChunkBase, base})
+	}
+	header := new(bytes.Buffer)
+	binary.Write(header, binary.BigEndian, &CommitGraphHeader{
+		Magic:       commitGraphMagic,
+		Version:     1,
+		HashVersion: HashSHA1.version,
+		Chunks:      byte(len(chunks)),
+		BaseGraphs:  byte(len(bases)),
+	})
+	buf := new(bytes.Buffer)
+	writeChunkFile(buf, header.Bytes(), chunks, HashSHA1)
+	return buf.Bytes()
+}
@@ -0,0 +1,323 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config is the content of a git config file. Keys are written in the form of
+// section.name or section.subsection.name. Section and variable names are
+// case-insensitive, subsections are case-sensitive.
+type Config struct {
+	entries []configEntry
+}
+
+type configEntry struct {
+	key   string
+	value string
+	// implicit is set for a variable without "=", which means true.
+	implicit bool
+}
+
+// ParseConfig parses a config file. Include directives are not followed.
+func ParseConfig(r io.Reader) (*Config, error) {
+	c := new(Config)
+	br := bufio.NewReader(r)
+	var section string
+	for lineno := 1; ; lineno++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line == "" && err == io.EOF {
+			break
+		}
+		// A value may continue to the next lines with a trailing backslash.
+		for err == nil && continuesLine(line) {
+			var next string
+			next, err = br.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			line += next
+			lineno++
+		}
+
+		s := strings.TrimSpace(line)
+		switch {
+		case s == "" || s[0] == '#' || s[0] == ';':
+		case s[0] == '[':
+			if section, s, err = parseConfigSection(s); err != nil {
+				return nil, fmt.Errorf("Bad config line %d: %v", lineno, err)
+			}
+			if s != "" {
+				if err = c.parseVariable(section, s); err != nil {
+					return nil, fmt.Errorf("Bad config line %d: %v", lineno, err)
+				}
+			}
+		default:
+			if section == "" {
+				return nil, fmt.Errorf("Bad config line %d: variable outside of a section", lineno)
+			}
+			if err = c.parseVariable(section, s); err != nil {
+				return nil, fmt.Errorf("Bad config line %d: %v", lineno, err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return c, nil
+}
+
+// continuesLine reports whether line ends with an unescaped backslash.
+func continuesLine(line string) bool {
+	line = strings.TrimRight(line, "\r\n")
+	n := len(line) - len(strings.TrimRight(line, "\\"))
+	return n%2 == 1
+}
+
+// parseConfigSection parses a section header and returns the section name
+// followed by the subsection if any, and the rest of the line.
+func parseConfigSection(s string) (string, string, error) {
+	end := strings.IndexAny(s, " \t\"]")
+	if end < 0 {
+		return "", "", fmt.Errorf("Invalid section header")
+	}
+	name := strings.ToLower(s[1:end])
+	if name == "" || strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789-.") != "" {
+		return "", "", fmt.Errorf("Invalid section name: %s", name)
+	}
+	s = strings.TrimLeft(s[end:], " \t")
+	if s == "" {
+		return "", "", fmt.Errorf("Invalid section header")
+	}
+	if s[0] == ']' {
+		// The deprecated [section.subsection] syntax is case-insensitive.
+		return name, strings.TrimSpace(s[1:]), nil
+	}
+	if s[0] != '"' {
+		return "", "", fmt.Errorf("Invalid section header")
+	}
+	var sub bytes.Buffer
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if i++; i == len(s) {
+				return "", "", fmt.Errorf("Invalid section header")
+			}
+			sub.WriteByte(s[i])
+		case '"':
+			rest := s[i+1:]
+			if !strings.HasPrefix(rest, "]") {
+				return "", "", fmt.Errorf("Invalid section header")
+			}
+			return name + "." + sub.String(), strings.TrimSpace(rest[1:]), nil
+		default:
+			sub.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("Invalid section header")
+}
+
+func (c *Config) parseVariable(section, s string) error {
+	end := strings.IndexAny(s, " \t=#;")
+	if end < 0 {
+		end = len(s)
+	}
+	name := strings.ToLower(s[:end])
+	if name == "" || !isASCIILetter(name[0]) || strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+		return fmt.Errorf("Invalid variable name: %s", s[:end])
+	}
+	entry := configEntry{key: section + "." + name}
+	s = strings.TrimLeft(s[end:], " \t")
+	if s == "" || s[0] == '#' || s[0] == ';' {
+		entry.implicit = true
+	} else if s[0] != '=' {
+		return fmt.Errorf("Invalid variable: %s", name)
+	} else {
+		value, err := parseConfigValue(s[1:])
+		if err != nil {
+			return err
+		}
+		entry.value = value
+	}
+	c.entries = append(c.entries, entry)
+	return nil
+}
+
+// parseConfigValue unquotes a value. Surrounding whitespace is removed, and
+// whitespace in double quotes is kept as is.
+func parseConfigValue(s string) (string, error) {
+	var buf bytes.Buffer
+	var quoted bool
+	// trim is the length of buf before trailing unquoted whitespace.
+	trim := -1
+	s = strings.TrimLeft(s, " \t")
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\r' || c == '\n':
+			continue
+		case c == '"':
+			quoted = !quoted
+			trim = -1
+			continue
+		case (c == '#' || c == ';') && !quoted:
+			i = len(s)
+			continue
+		case c == '\\':
+			if i++; i == len(s) {
+				return "", fmt.Errorf("Invalid escape at the end of value")
+			}
+			switch s[i] {
+			case '\n':
+				// Line continuation
+				continue
+			case '\r':
+				if i+1 < len(s) && s[i+1] == '\n' {
+					i++
+				}
+				continue
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case '\\', '"':
+				c = s[i]
+			default:
+				return "", fmt.Errorf("Invalid escape: \\%c", s[i])
+			}
+			trim = -1
+		case (c == ' ' || c == '\t') && !quoted:
+			if trim < 0 {
+				trim = buf.Len()
+			}
+		default:
+			trim = -1
+		}
+		buf.WriteByte(c)
+	}
+	if quoted {
+		return "", fmt.Errorf("Unterminated quote")
+	}
+	if trim >= 0 {
+		buf.Truncate(trim)
+	}
+	return buf.String(), nil
+}
+
+func isASCIILetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// normalizeConfigKey lowercases the section and the variable name of key.
+func normalizeConfigKey(key string) string {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first < 0 {
+		return strings.ToLower(key)
+	}
+	return strings.ToLower(key[:first]) + key[first:last] + strings.ToLower(key[last:])
+}
+
+// Get returns the last value of key. The second result reports whether the key
+// exists.
+func (c *Config) Get(key string) (string, bool) {
+	key = normalizeConfigKey(key)
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		if c.entries[i].key == key {
+			return c.entries[i].value, true
+		}
+	}
+	return "", false
+}
+
+// GetAll returns all values of a multi-valued key in order.
+func (c *Config) GetAll(key string) []string {
+	key = normalizeConfigKey(key)
+	var values []string
+	for _, entry := range c.entries {
+		if entry.key == key {
+			values = append(values, entry.value)
+		}
+	}
+	return values
+}
+
+// Bool returns the last value of key as a boolean. def is returned if the key
+// doesn't exist.
+func (c *Config) Bool(key string, def bool) (bool, error) {
+	key = normalizeConfigKey(key)
+	for i := len(c.entries) - 1; i >= 0; i-- {
+		entry := c.entries[i]
+		if entry.key != key {
+			continue
+		}
+		if entry.implicit {
+			return true, nil
+		}
+		switch strings.ToLower(entry.value) {
+		case "true", "yes", "on", "1":
+			return true, nil
+		case "false", "no", "off", "0", "":
+			return false, nil
+		}
+		return false, fmt.Errorf("Bad boolean config value '%s' for '%s'", entry.value, key)
+	}
+	return def, nil
+}
+
+// Int returns the last value of key as an integer. A unit suffix k, m or g is
+// allowed. def is returned if the key doesn't exist.
+func (c *Config) Int(key string, def int64) (int64, error) {
+	s, ok := c.Get(key)
+	if !ok {
+		return def, nil
+	}
+	var unit int64 = 1
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			unit = 1 << 10
+		case 'm', 'M':
+			unit = 1 << 20
+		case 'g', 'G':
+			unit = 1 << 30
+		}
+		if unit > 1 {
+			s = s[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Bad numeric config value '%s' for '%s'", s, normalizeConfigKey(key))
+	}
+	return n * unit, nil
+}
+
+// readConfig reads the config file of a git directory. An empty config is
+// returned if the file doesn't exist.
+func readConfig(dir string) (*Config, error) {
+	f, err := os.Open(filepath.Join(dir, "config"))
+	if os.IsNotExist(err) {
+		return new(Config), nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseConfig(f)
+}
+
+// Config returns the config of the repository read when it's opened.
+func (r *Repository) Config() *Config {
+	return r.config
+}
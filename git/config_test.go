@@ -0,0 +1,82 @@
+package git
+
+import (
+	"strings"
+	"testing"
+)
+
+const testConfig = `# comment
+[core]
+	repositoryformatversion = 0
+	Bare = false ; inline comment
+	filemode
+[Remote "origin"]
+	url = "https://example.com/repo.git"
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+[branch.main]
+	remote = origin
+[pack]
+	windowMemory = 10k
+	message = "a \"quoted\"\tvalue" # comment
+	long = first \
+second
+`
+
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for key, expected := range map[string]string{
+		"core.repositoryFormatVersion": "0",
+		"remote.origin.url":            "https://example.com/repo.git",
+		"REMOTE.origin.FETCH":          "+refs/tags/*:refs/tags/*",
+		"branch.main.remote":           "origin",
+		"pack.message":                 "a \"quoted\"\tvalue",
+		"pack.long":                    "first second",
+	} {
+		if v, ok := c.Get(key); !ok || v != expected {
+			t.Errorf("%s: unexpected value: %q", key, v)
+		}
+	}
+	if _, ok := c.Get("remote.Origin.url"); ok {
+		t.Errorf("Subsection must be case-sensitive")
+	}
+	if v := c.GetAll("remote.origin.fetch"); len(v) != 2 {
+		t.Errorf("Unexpected values: %q", v)
+	}
+
+	if v, err := c.Bool("core.bare", true); err != nil || v {
+		t.Errorf("core.bare: unexpected value: %v, %v", v, err)
+	}
+	if v, err := c.Bool("core.filemode", false); err != nil || !v {
+		t.Errorf("core.filemode: unexpected value: %v, %v", v, err)
+	}
+	if v, err := c.Bool("core.missing", true); err != nil || !v {
+		t.Errorf("core.missing: unexpected value: %v, %v", v, err)
+	}
+	if _, err := c.Bool("remote.origin.url", false); err == nil {
+		t.Errorf("Expected error for a non-boolean value")
+	}
+	if v, err := c.Int("pack.windowmemory", 0); err != nil || v != 10*1024 {
+		t.Errorf("pack.windowmemory: unexpected value: %v, %v", v, err)
+	}
+	if v, err := c.Int("pack.missing", 42); err != nil || v != 42 {
+		t.Errorf("pack.missing: unexpected value: %v, %v", v, err)
+	}
+}
+
+func TestParseConfigError(t *testing.T) {
+	for _, s := range []string{
+		"key = value\n",
+		"[core\nbare = true\n",
+		"[core]\n1key = value\n",
+		"[core]\nkey = \"unterminated\n",
+	} {
+		if _, err := ParseConfig(strings.NewReader(s)); err == nil {
+			t.Errorf("Expected error: %q", s)
+		}
+	}
+}
@@ -1,13 +1,14 @@
 package git
 
 import (
+	"bytes"
 	"errors"
 	"io"
 )
 
 var ErrInvalidDelta = errors.New("invalid delta")
 
-func applyDelta(srcEntry objectEntry, delta *bytesBuffer) (*bytesBuffer, error) {
+func applyDelta(srcEntry ObjectEntry, delta *bytesBuffer) (*bytesBuffer, error) {
 	defer srcEntry.Close()
 	defer delta.Close()
 	src, err := srcEntry.ReadAll()
@@ -90,3 +91,108 @@ func deltaHeaderSize(br byteReader) (int, error) {
 	}
 	return size, nil
 }
+
+const (
+	deltaBlockSize = 16
+	deltaMaxCopy   = 0x10000
+	deltaMaxInsert = 0x7f
+)
+
+// createDelta computes a delta which reproduces dst from src when applied by
+// applyDelta. Source is indexed by fixed size blocks and each match is
+// extended as long as possible.
+func createDelta(src, dst []byte) []byte {
+	out := appendDeltaSize(nil, len(src))
+	out = appendDeltaSize(out, len(dst))
+
+	index := make(map[uint32]int)
+	for i := 0; i+deltaBlockSize <= len(src); i += deltaBlockSize {
+		h := deltaBlockHash(src[i : i+deltaBlockSize])
+		if _, ok := index[h]; !ok {
+			index[h] = i
+		}
+	}
+
+	var lit int // start of pending literal bytes
+	for i := 0; i < len(dst); {
+		if i+deltaBlockSize > len(dst) {
+			break
+		}
+		pos, ok := index[deltaBlockHash(dst[i:i+deltaBlockSize])]
+		if !ok || !bytes.Equal(src[pos:pos+deltaBlockSize], dst[i:i+deltaBlockSize]) {
+			i++
+			continue
+		}
+		// Extend the match backward into pending literals and forward.
+		for pos > 0 && i > lit && src[pos-1] == dst[i-1] {
+			pos--
+			i--
+		}
+		n := deltaBlockSize
+		for pos+n < len(src) && i+n < len(dst) && src[pos+n] == dst[i+n] {
+			n++
+		}
+		out = appendDeltaInsert(out, dst[lit:i])
+		out = appendDeltaCopy(out, pos, n)
+		i += n
+		lit = i
+	}
+	return appendDeltaInsert(out, dst[lit:])
+}
+
+func deltaBlockHash(b []byte) uint32 {
+	h := uint32(2166136261)
+	for _, c := range b {
+		h = (h ^ uint32(c)) * 16777619
+	}
+	return h
+}
+
+func appendDeltaSize(out []byte, size int) []byte {
+	for size >= 0x80 {
+		out = append(out, byte(size&0x7f)|0x80)
+		size >>= 7
+	}
+	return append(out, byte(size))
+}
+
+func appendDeltaInsert(out []byte, data []byte) []byte {
+	for len(data) > 0 {
+		n := len(data)
+		if n > deltaMaxInsert {
+			n = deltaMaxInsert
+		}
+		out = append(out, byte(n))
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
+
+func appendDeltaCopy(out []byte, offset, size int) []byte {
+	for size > 0 {
+		n := size
+		if n > deltaMaxCopy {
+			n = deltaMaxCopy
+		}
+		op := len(out)
+		out = append(out, 0x80)
+		for i := uint(0); i < 4; i++ {
+			if b := byte(offset >> (8 * i)); b != 0 {
+				out[op] |= 1 << i
+				out = append(out, b)
+			}
+		}
+		if n != deltaMaxCopy {
+			for i := uint(0); i < 3; i++ {
+				if b := byte(n >> (8 * i)); b != 0 {
+					out[op] |= 1 << (4 + i)
+					out = append(out, b)
+				}
+			}
+		}
+		offset += n
+		size -= n
+	}
+	return out
+}
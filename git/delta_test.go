@@ -0,0 +1,47 @@
+package git
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestCreateDelta(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		rnd.Read(b)
+		return b
+	}
+	base := random(200000)
+	modified := append(append(cloneBytes(base[:1000]), random(100)...), base[1500:]...)
+
+	for _, tc := range []struct {
+		name     string
+		src, dst []byte
+	}{
+		{"empty", nil, nil},
+		{"identical", base, base},
+		{"modified", base, modified},
+		{"appended", base[:100], append(cloneBytes(base[:100]), random(300)...)},
+		{"unrelated", random(1000), random(500)},
+		{"to empty", base[:100], nil},
+	} {
+		delta := createDelta(tc.src, tc.dst)
+		buf := acquireBytesBuffer()
+		buf.Write(delta)
+		out, err := applyDelta(&memoryEntry{typ: "blob", data: tc.src}, buf)
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", tc.name, err)
+			continue
+		}
+		if !bytes.Equal(out.Bytes(), tc.dst) {
+			t.Errorf("%s: Delta result mismatch", tc.name)
+		}
+		out.Close()
+	}
+
+	if delta := createDelta(base, modified); len(delta) > 1000 {
+		t.Errorf("Delta is too large: %d", len(delta))
+	}
+}
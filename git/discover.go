@@ -0,0 +1,161 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Discover finds the repository which path belongs to. Like git command, it
+// walks up the directory hierarchy from path until a repository is found. A
+// .git file containing "gitdir: <path>" (used by worktrees and submodules) is
+// followed. GIT_DIR, GIT_WORK_TREE and GIT_CEILING_DIRECTORIES environment
+// variables are also honoured.
+func Discover(path string) (*Repository, error) {
+	worktree := os.Getenv("GIT_WORK_TREE")
+	if worktree != "" {
+		var err error
+		if worktree, err = filepath.Abs(worktree); err != nil {
+			return nil, err
+		}
+	}
+	if dir := os.Getenv("GIT_DIR"); dir != "" {
+		return openGitDirEnv(dir, worktree)
+	}
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	ceilings := ceilingDirs()
+	for dir := path; ; {
+		root, err := dotGitAt(dir)
+		if err != nil {
+			return nil, err
+		}
+		if root != "" {
+			if worktree != "" {
+				dir = worktree
+			}
+			return newRepository(dir, root, false)
+		}
+		if isGitDir(dir) {
+			if worktree != "" {
+				return newRepository(worktree, dir, false)
+			}
+			return newRepository(dir, dir, true)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir || ceilings[parent] {
+			break
+		}
+		dir = parent
+	}
+	return nil, fmt.Errorf("Not a git repository: %s", path)
+}
+
+// openGitDirEnv opens the repository specified by GIT_DIR. If GIT_WORK_TREE is
+// not given, the parent of a directory named .git is used as the work tree,
+// otherwise the repository is considered bare.
+func openGitDirEnv(dir, worktree string) (*Repository, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !fi.IsDir() {
+		if dir, err = readGitFile(dir); err != nil {
+			return nil, err
+		}
+	}
+	if !isGitDir(dir) {
+		return nil, fmt.Errorf("Not a git repository: %s", dir)
+	}
+	switch {
+	case worktree != "":
+		return newRepository(worktree, dir, false)
+	case filepath.Base(dir) == ".git":
+		return newRepository(filepath.Dir(dir), dir, false)
+	}
+	return newRepository(dir, dir, true)
+}
+
+// dotGitAt returns the git directory pointed by .git in dir. It returns an
+// empty string if dir doesn't have a valid .git.
+func dotGitAt(dir string) (string, error) {
+	path := filepath.Join(dir, ".git")
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		if path, err = readGitFile(path); err != nil {
+			return "", err
+		}
+	}
+	if !isGitDir(path) {
+		return "", nil
+	}
+	return path, nil
+}
+
+// readGitFile reads a gitdir file and returns the absolute path it points to.
+func readGitFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	prefix := []byte("gitdir: ")
+	if !bytes.HasPrefix(b, prefix) {
+		return "", fmt.Errorf("Invalid gitfile format: %s", path)
+	}
+	dir := string(bytes.TrimSpace(b[len(prefix):]))
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(path), dir)
+	}
+	return filepath.Clean(dir), nil
+}
+
+// readCommonDir returns the directory shared by all worktrees if dir is a
+// linked worktree's git directory, otherwise dir itself.
+func readCommonDir(dir string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "commondir"))
+	if err != nil {
+		return dir
+	}
+	common := string(bytes.TrimSpace(b))
+	if !filepath.IsAbs(common) {
+		common = filepath.Join(dir, common)
+	}
+	return filepath.Clean(common)
+}
+
+// isGitDir checks whether dir looks like a git directory from its layout.
+func isGitDir(dir string) bool {
+	if fi, err := os.Stat(filepath.Join(dir, "HEAD")); err != nil || fi.IsDir() {
+		return false
+	}
+	common := readCommonDir(dir)
+	for _, name := range []string{"objects", "refs"} {
+		if fi, err := os.Stat(filepath.Join(common, name)); err != nil || !fi.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+func ceilingDirs() map[string]bool {
+	ceilings := make(map[string]bool)
+	for _, dir := range filepath.SplitList(os.Getenv("GIT_CEILING_DIRECTORIES")) {
+		if filepath.IsAbs(dir) {
+			ceilings[filepath.Clean(dir)] = true
+		}
+	}
+	return ceilings
+}
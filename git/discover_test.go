@@ -0,0 +1,90 @@
+package git
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiscover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"GIT_DIR", "GIT_WORK_TREE", "GIT_CEILING_DIRECTORIES"} {
+		defer restoreEnv(name)()
+		os.Unsetenv(name)
+	}
+
+	work := filepath.Join(dir, "work")
+	if _, err = Init(work, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	bare := filepath.Join(dir, "bare")
+	if _, err = Init(bare, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	linked := filepath.Join(dir, "linked")
+	sub := filepath.Join(linked, "a", "b")
+	if err = os.MkdirAll(sub, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(linked, ".git"), []byte("gitdir: ../bare\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(filepath.Join(work, "x", "y"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		path string
+		repo string
+		root string
+		bare bool
+	}{
+		{work, work, filepath.Join(work, ".git"), false},
+		{filepath.Join(work, "x", "y"), work, filepath.Join(work, ".git"), false},
+		{filepath.Join(work, ".git"), filepath.Join(work, ".git"), filepath.Join(work, ".git"), true},
+		{bare, bare, bare, true},
+		{filepath.Join(bare, "refs", "heads"), bare, bare, true},
+		{sub, linked, bare, false},
+	} {
+		repo, err := Discover(tc.path)
+		if err != nil {
+			t.Errorf("Path: %s, Unexpected error: %v", tc.path, err)
+			continue
+		}
+		if repo.Path != tc.repo || repo.root != tc.root || repo.Bare != tc.bare {
+			t.Errorf("Path: %s, Unexpected repository: %+v", tc.path, repo)
+		}
+	}
+
+	os.Setenv("GIT_CEILING_DIRECTORIES", linked)
+	if repo, err := Discover(sub); err == nil {
+		t.Errorf("Unexpected repository beyond ceiling: %+v", repo)
+	}
+	os.Unsetenv("GIT_CEILING_DIRECTORIES")
+
+	os.Setenv("GIT_DIR", bare)
+	os.Setenv("GIT_WORK_TREE", sub)
+	repo, err := Discover(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if repo.Path != sub || repo.root != bare || repo.Bare {
+		t.Errorf("Unexpected repository: %+v", repo)
+	}
+}
+
+func restoreEnv(name string) func() {
+	value, ok := os.LookupEnv(name)
+	return func() {
+		if ok {
+			os.Setenv(name, value)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FsckResult is the result of Fsck.
+type FsckResult struct {
+	// Corrupt is objects which can't be read or whose content doesn't match
+	// the id.
+	Corrupt []*FsckError
+	// Invalid is objects whose content is malformed, or which refer objects
+	// of an unexpected type.
+	Invalid []*FsckError
+	// Missing is objects referred but not found.
+	Missing []*MissingObject
+	// Promised is objects not found but referred by objects in promisor
+	// packs of a partial clone. They're not problems.
+	Promised []*MissingObject
+	// Dangling is objects neither reachable from refs nor referred by other
+	// objects.
+	Dangling []SHA1
+	// Warnings is objects which git fsck only warns about, like trees with
+	// legacy modes. They're not problems.
+	Warnings []*FsckError
+}
+
+// OK reports whether no problem is found. Dangling objects are not problems.
+func (r *FsckResult) OK() bool {
+	return len(r.Corrupt) == 0 && len(r.Invalid) == 0 && len(r.Missing) == 0
+}
+
+type FsckError struct {
+	ID   SHA1
+	Type string
+	Err  error
+}
+
+func (e *FsckError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Type, e.ID, e.Err)
+}
+
+type MissingObject struct {
+	ID SHA1
+	// Type is the expected type of the object.
+	Type string
+	// From is the id of the object or the name of the ref which refers the
+	// missing object.
+	From string
+}
+
+var ErrHashMismatch = errors.New("Hash mismatch")
+
+// fsckLink is a reference from an object to another object.
+type fsckLink struct {
+	from SHA1
+	to   SHA1
+	typ  string
+}
+
+// Fsck checks the integrity of all objects in the repository. Each object is
+// rehashed and its syntax is validated, then the connectivity from refs is
+// checked. Problems are reported in the result rather than as an error, the
+// error is returned only if the check itself fails.
+func (r *Repository) Fsck() (*FsckResult, error) {
+	result := new(FsckResult)
+	types := make(map[SHA1]string)
+	var links []fsckLink
+
+	err := r.store.ForEach(func(id SHA1) error {
+		types[id] = ""
+		entry, err := r.store.Entry(id)
+		if err != nil {
+			result.Corrupt = append(result.Corrupt, &FsckError{id, "", err})
+			return nil
+		}
+		defer entry.Close()
+		typ := entry.Type()
+		data, err := entry.ReadAll()
+		if err != nil {
+			result.Corrupt = append(result.Corrupt, &FsckError{id, typ, err})
+			return nil
+		}
+		if r.hash.hashObject(typ, data) != id {
+			result.Corrupt = append(result.Corrupt, &FsckError{id, typ, ErrHashMismatch})
+			return nil
+		}
+		types[id] = typ
+
+		var (
+			refs     []fsckLink
+			warnings []error
+		)
+		switch typ {
+		case "commit":
+			refs, err = fsckCommit(data, r.hash)
+		case "tree":
+			refs, warnings, err = fsckTree(data, r.hash)
+		case "tag":
+			refs, err = fsckTag(data, r.hash)
+		case "blob":
+		default:
+			err = fmt.Errorf("unknown type %s", typ)
+		}
+		if err != nil {
+			result.Invalid = append(result.Invalid, &FsckError{id, typ, err})
+		}
+		for _, warning := range warnings {
+			result.Warnings = append(result.Warnings, &FsckError{id, typ, warning})
+		}
+		for _, link := range refs {
+			link.from = id
+			links = append(links, link)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	shallow, err := r.shallowCommits()
+	if err != nil {
+		return nil, err
+	}
+	promisor := r.promisorObjects()
+	referred := make(map[SHA1]bool)
+	for _, link := range links {
+		// Parents of shallow commits are expected to be missing.
+		if shallow[link.from] && link.typ == "commit" {
+			continue
+		}
+		referred[link.to] = true
+		typ, ok := types[link.to]
+		if !ok {
+			missing := &MissingObject{link.to, link.typ, link.from.String()}
+			if promisor[link.from] {
+				result.Promised = append(result.Promised, missing)
+			} else {
+				result.Missing = append(result.Missing, missing)
+			}
+		} else if typ != "" && link.typ != "" && typ != link.typ {
+			err := fmt.Errorf("%s is a %s, not a %s", link.to, typ, link.typ)
+			result.Invalid = append(result.Invalid, &FsckError{link.from, types[link.from], err})
+		}
+	}
+
+	roots, err := r.rootObjects()
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		referred[root.ID] = true
+		if _, ok := types[root.ID]; !ok {
+			result.Missing = append(result.Missing, &MissingObject{root.ID, "", root.Name})
+		}
+	}
+	for id, typ := range types {
+		// Unreadable objects are already reported as corrupt.
+		if !referred[id] && typ != "" {
+			result.Dangling = append(result.Dangling, id)
+		}
+	}
+	sort.Sort(bySHA1(result.Dangling))
+	return result, nil
+}
+
+func fsckCommit(data []byte, h *HashAlgo) ([]fsckLink, error) {
+	var links []fsckLink
+	value, data, err := readKV(data, "tree ")
+	if err != nil {
+		return nil, errors.New("missing tree")
+	}
+	id, ok := parseHexID(string(value))
+	if !ok || id.Algo() != h {
+		return nil, errors.New("invalid tree")
+	}
+	links = append(links, fsckLink{to: id, typ: "tree"})
+
+	for {
+		value, data, err = readKV(data, "parent ")
+		if err == ErrPrefixNotMatch {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if id, ok = parseHexID(string(value)); !ok || id.Algo() != h {
+			return nil, errors.New("invalid parent")
+		}
+		links = append(links, fsckLink{to: id, typ: "commit"})
+	}
+
+	for _, key := range []string{"author", "committer"} {
+		if value, data, err = readKV(data, key+" "); err != nil {
+			return nil, fmt.Errorf("missing %s", key)
+		}
+		if err = fsckIdent(value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+	}
+	return links, fsckHeaderEnd(data)
+}
+
+func fsckTag(data []byte, h *HashAlgo) ([]fsckLink, error) {
+	value, data, err := readKV(data, "object ")
+	if err != nil {
+		return nil, errors.New("missing object")
+	}
+	id, ok := parseHexID(string(value))
+	if !ok || id.Algo() != h {
+		return nil, errors.New("invalid object")
+	}
+	if value, data, err = readKV(data, "type "); err != nil {
+		return nil, errors.New("missing type")
+	}
+	typ := string(value)
+	switch typ {
+	case "commit", "tree", "blob", "tag":
+	default:
+		return nil, fmt.Errorf("invalid type: %s", typ)
+	}
+	if value, data, err = readKV(data, "tag "); err != nil || len(value) == 0 {
+		return nil, errors.New("missing tag name")
+	}
+	// Very old tags don't have the tagger.
+	if value, data, err = readKV(data, "tagger "); err == nil {
+		if err = fsckIdent(value); err != nil {
+			return nil, fmt.Errorf("invalid tagger: %v", err)
+		}
+	} else if err != ErrPrefixNotMatch {
+		return nil, err
+	}
+	return []fsckLink{{to: id, typ: typ}}, fsckHeaderEnd(data)
+}
+
+// fsckHeaderEnd checks that the rest of headers is well-formed.
+func fsckHeaderEnd(data []byte) error {
+	for len(data) > 0 && data[0] != '\n' {
+		pos := bytes.IndexByte(data, '\n')
+		if pos == -1 {
+			return errors.New("unterminated header")
+		}
+		data = data[pos+1:]
+	}
+	return nil
+}
+
+// fsckIdent checks the identity in the form of "Name <email> 1234567890 +0900".
+func fsckIdent(b []byte) error {
+	s := string(b)
+	lt := strings.IndexByte(s, '<')
+	if lt == -1 {
+		return errors.New("missing email")
+	}
+	if lt == 0 || s[lt-1] != ' ' {
+		return errors.New("missing space before email")
+	}
+	if strings.ContainsAny(s[:lt], ">\n") {
+		return errors.New("bad name")
+	}
+	s = s[lt+1:]
+	gt := strings.IndexByte(s, '>')
+	if gt == -1 || strings.ContainsAny(s[:gt], "<\n") {
+		return errors.New("bad email")
+	}
+	s = s[gt+1:]
+	if len(s) == 0 || s[0] != ' ' {
+		return errors.New("missing space before date")
+	}
+	s = s[1:]
+	sp := strings.IndexByte(s, ' ')
+	if sp <= 0 || !isDigits(s[:sp]) || (s[0] == '0' && sp > 1) {
+		return errors.New("bad date")
+	}
+	tz := s[sp+1:]
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') || !isDigits(tz[1:]) {
+		return errors.New("bad timezone")
+	}
+	return nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// fsckTree checks that entries have valid modes and names, and are sorted in
+// the canonical order without duplicates. Legacy modes 100664 and 040000 which
+// old versions of git wrote are accepted with warnings same as git fsck.
+func fsckTree(data []byte, h *HashAlgo) (links []fsckLink, warnings []error, err error) {
+	var (
+		prev                string
+		names               = make(map[string]bool)
+		badMode, zeroPadded bool
+	)
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		if sp == -1 {
+			return nil, nil, errors.New("truncated tree")
+		}
+		mode := string(data[:sp])
+		data = data[sp+1:]
+		nul := bytes.IndexByte(data, 0)
+		if nul == -1 || len(data) < nul+1+h.Size {
+			return nil, nil, errors.New("truncated tree")
+		}
+		name := string(data[:nul])
+		id := h.FromBytes(data[nul+1 : nul+1+h.Size])
+		data = data[nul+1+h.Size:]
+
+		var typ, canonical string
+		switch mode {
+		case "100644", "100755", "120000":
+			typ, canonical = "blob", name
+		case "100664":
+			typ, canonical, badMode = "blob", name, true
+		case "40000":
+			typ, canonical = "tree", name+"/"
+		case "040000":
+			typ, canonical, zeroPadded = "tree", name+"/", true
+		case "160000":
+			canonical = name
+		default:
+			return nil, nil, fmt.Errorf("bad mode %s of %s", mode, name)
+		}
+		if name == "" || name == "." || name == ".." || strings.EqualFold(name, ".git") ||
+			strings.IndexByte(name, '/') != -1 {
+			return nil, nil, fmt.Errorf("bad name %q", name)
+		}
+		if names[name] {
+			return nil, nil, fmt.Errorf("duplicate entry %s", name)
+		}
+		names[name] = true
+		if prev != "" && canonical <= prev {
+			return nil, nil, fmt.Errorf("not sorted at %s", name)
+		}
+		prev = canonical
+		// Gitlinks point commits in other repositories.
+		if typ != "" {
+			links = append(links, fsckLink{to: id, typ: typ})
+		}
+	}
+	if badMode {
+		warnings = append(warnings, errors.New("contains bad file modes"))
+	}
+	if zeroPadded {
+		warnings = append(warnings, errors.New("contains zero-padded file modes"))
+	}
+	return links, warnings, nil
+}
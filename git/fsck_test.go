@@ -0,0 +1,147 @@
+package git
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFsck(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	ids := newTestObjects(t, repo, 3)
+	if err := repo.NewRef(BranchRef("master"), ids[len(ids)-1]).Write(); err != nil {
+		t.Fatal(err)
+	}
+	result, err := repo.Fsck()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.OK() || len(result.Dangling) != 0 {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+
+	write := func(typ, data string) SHA1 {
+		id, err := repo.writeObject(typ, bytes.NewReader([]byte(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	dangling := write("blob", "dangling")
+	badTree := write("tree", "100644 b\x00"+string(dangling.Bytes())+"100644 a\x00"+string(dangling.Bytes()))
+	missing := SHA1FromBytes([]byte{1})
+	orphan := write("commit", "tree "+badTree.String()+"\nparent "+missing.String()+
+		"\nauthor a <a@example.com> 0 +0000\ncommitter a <a@example.com> 0 +0000\n\norphan\n")
+
+	// A loose object stored under a wrong id.
+	other := write("blob", "other")
+	corrupt := HashSHA1.hashObject("blob", []byte("corrupt"))
+	from, to := other.String(), corrupt.String()
+	os.MkdirAll(filepath.Join(dir, "objects", to[:2]), 0777)
+	if err = os.Rename(filepath.Join(dir, "objects", from[:2], from[2:]), filepath.Join(dir, "objects", to[:2], to[2:])); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err = repo.Fsck(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.OK() {
+		t.Fatalf("Problems are not found")
+	}
+	if len(result.Corrupt) != 1 || result.Corrupt[0].ID != corrupt || result.Corrupt[0].Err != ErrHashMismatch {
+		t.Errorf("Unexpected corrupt objects: %v", result.Corrupt)
+	}
+	if len(result.Invalid) != 1 || result.Invalid[0].ID != badTree {
+		t.Errorf("Unexpected invalid objects: %v", result.Invalid)
+	}
+	if len(result.Missing) != 1 || *result.Missing[0] != (MissingObject{missing, "commit", orphan.String()}) {
+		t.Errorf("Unexpected missing objects: %v", result.Missing)
+	}
+	// The dangling blob is referred by the invalid tree, but its entries are
+	// not trusted.
+	if len(result.Dangling) != 2 {
+		t.Errorf("Unexpected dangling objects: %v", result.Dangling)
+	}
+}
+
+func TestFsckTree(t *testing.T) {
+	id := string(make([]byte, 20))
+	for _, tc := range []struct {
+		data     string
+		ok       bool
+		warnings int
+	}{
+		{"40000 a\x00" + id + "100644 a.b\x00" + id, false, 0},
+		{"100644 a.b\x00" + id + "40000 a\x00" + id + "120000 a0\x00" + id + "160000 b\x00" + id, true, 0},
+		{"100644 a\x00" + id + "40000 a\x00" + id, false, 0},
+		{"100664 a\x00" + id, true, 1},
+		{"040000 a\x00" + id, true, 1},
+		{"100664 a\x00" + id + "040000 b\x00" + id + "100664 c\x00" + id, true, 2},
+		{"100600 a\x00" + id, false, 0},
+		{"100644 .git\x00" + id, false, 0},
+		{"100644 a/b\x00" + id, false, 0},
+		{"100644 a\x00" + id[:10], false, 0},
+	} {
+		_, warnings, err := fsckTree([]byte(tc.data), HashSHA1)
+		if (err == nil) != tc.ok || len(warnings) != tc.warnings {
+			t.Errorf("%q: Unexpected result: %v, %v", tc.data, warnings, err)
+		}
+	}
+}
+
+func TestFsckLegacyModes(t *testing.T) {
+	repo, _ := newTestRepo(t, true)
+	write := func(typ, data string) SHA1 {
+		id, err := repo.writeObject(typ, bytes.NewReader([]byte(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	blob := write("blob", "legacy")
+	subtree := write("tree", "100644 file\x00"+string(blob.Bytes()))
+	tree := write("tree", "100664 a\x00"+string(blob.Bytes())+"040000 b\x00"+string(subtree.Bytes()))
+	commit := write("commit", "tree "+tree.String()+
+		"\nauthor a <a@example.com> 0 +0000\ncommitter a <a@example.com> 0 +0000\n\nlegacy\n")
+	if err := repo.NewRef(BranchRef("master"), commit).Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := repo.Fsck()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.OK() || len(result.Dangling) != 0 {
+		t.Fatalf("Unexpected result: %+v", result)
+	}
+	if len(result.Warnings) != 2 || result.Warnings[0].ID != tree || result.Warnings[1].ID != tree {
+		t.Errorf("Unexpected warnings: %v", result.Warnings)
+	}
+	reachable, err := repo.ReachableObjects([]SHA1{commit})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(reachable) != 4 {
+		t.Errorf("Unexpected reachable objects: %v", reachable)
+	}
+}
+
+func TestFsckIdent(t *testing.T) {
+	for _, tc := range []struct {
+		ident string
+		ok    bool
+	}{
+		{"go-git <go-git@example.com> 1234567890 +0900", true},
+		{" <go-git@example.com> 0 -0000", true},
+		{"go-git<go-git@example.com> 1234567890 +0900", false},
+		{"go-git <go-git@example.com 1234567890 +0900", false},
+		{"go-git <go-git@example.com> 01234567890 +0900", false},
+		{"go-git <go-git@example.com> 1234567890 0900", false},
+		{"go-git <go-git@example.com>", false},
+	} {
+		if err := fsckIdent([]byte(tc.ident)); (err == nil) != tc.ok {
+			t.Errorf("%q: Unexpected result: %v", tc.ident, err)
+		}
+	}
+}
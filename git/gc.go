@@ -0,0 +1,354 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const defaultPruneExpire = 14 * 24 * time.Hour
+
+type GCOptions struct {
+	// PruneExpire is the grace period of unreachable objects. Unreachable
+	// objects modified within the period are kept as loose objects. Default
+	// is 2 weeks.
+	PruneExpire time.Duration
+	// PruneNow prunes all unreachable objects regardless of PruneExpire.
+	PruneNow bool
+}
+
+// GC packs all reachable objects in the repository into a single new pack,
+// then removes loose objects and packs made redundant by it. Packs which have
+// a .keep file and promisor packs of a partial clone are left as is, and
+// objects in them are not repacked.
+// Unreachable objects older than the grace period are pruned, and the recent
+// ones are kept as loose objects together with objects reachable from them.
+//
+// Objects are reachable if they're reachable from refs, HEAD and private refs
+// of each worktree, reflogs or the index of each worktree. GC fails without
+// removing anything if any of them can't be read.
+func (r *Repository) GC(opts *GCOptions) error {
+	if opts == nil {
+		opts = &GCOptions{}
+	}
+	expire := opts.PruneExpire
+	if expire == 0 {
+		expire = defaultPruneExpire
+	}
+	cutoff := time.Now().Add(-expire)
+	if opts.PruneNow {
+		cutoff = time.Now().Add(time.Hour)
+	}
+
+	store, ok := r.store.(*fsObjectStore)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := store.open(); err != nil {
+		return err
+	}
+	dir := store.dirs[0]
+	if err := dir.openPack(); err != nil {
+		return err
+	}
+
+	roots, err := r.rootObjects()
+	if err != nil {
+		return err
+	}
+	tips := make([]SHA1, len(roots))
+	for i, root := range roots {
+		tips[i] = root.ID
+	}
+	reachable, err := r.ReachableObjects(tips)
+	if err != nil {
+		return err
+	}
+
+	kept := make(map[SHA1]bool)
+	var oldPacks []*Pack
+	for _, pack := range dir.packs {
+		if _, err := os.Stat(pack.basePath() + ".keep"); err == nil || pack.isPromisor() {
+			forEachPackObject(pack, func(id SHA1) { kept[id] = true })
+		} else {
+			oldPacks = append(oldPacks, pack)
+		}
+	}
+
+	// Reachable objects are packed unless they're in kept packs or only
+	// available from alternates.
+	w := NewPackWriter(r)
+	packed := make(map[SHA1]bool)
+	for _, id := range reachable {
+		if !kept[id] && dir.has(id) {
+			w.Add(id)
+			packed[id] = true
+		}
+	}
+
+	// Unreachable objects modified recently and objects reachable from them
+	// are retained as loose objects.
+	loose, err := looseObjectTimes(dir.path)
+	if err != nil {
+		return err
+	}
+	var recent []SHA1
+	for id, mtime := range loose {
+		if !packed[id] && !kept[id] && mtime.After(cutoff) {
+			recent = append(recent, id)
+		}
+	}
+	loosen := make(map[SHA1]time.Time)
+	for _, pack := range oldPacks {
+		fi, err := os.Stat(pack.path)
+		if err != nil {
+			return err
+		}
+		forEachPackObject(pack, func(id SHA1) {
+			if !packed[id] && !kept[id] {
+				loosen[id] = fi.ModTime()
+				if fi.ModTime().After(cutoff) {
+					recent = append(recent, id)
+				}
+			}
+		})
+	}
+	retained := make(map[SHA1]bool)
+	err = r.walkObjects(recent, func(id SHA1) (bool, error) {
+		if retained[id] || packed[id] || kept[id] || !r.store.Has(id) {
+			return false, nil
+		}
+		retained[id] = true
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var newPack string
+	if w.Len() > 0 {
+		if newPack, err = w.Save(filepath.Join(dir.path, "pack")); err != nil {
+			return err
+		}
+	}
+	for id, mtime := range loosen {
+		if !retained[id] {
+			continue
+		}
+		if _, ok := loose[id]; ok {
+			continue
+		}
+		if err = loosenObject(store, id, mtime); err != nil {
+			return err
+		}
+	}
+
+	// Everything retained is now in the new pack, kept packs or loose
+	// objects. Close all packs before removing files since they're reopened
+	// on the next access.
+	store.close()
+	for _, pack := range oldPacks {
+		// The same pack is written again if nothing has changed.
+		if pack.path == newPack {
+			continue
+		}
+		if err = removePack(pack); err != nil {
+			return err
+		}
+	}
+	if err = removeIfExists(filepath.Join(dir.path, "pack", multiPackIndexName)); err != nil {
+		return err
+	}
+	for id := range loose {
+		if retained[id] {
+			continue
+		}
+		h := id.String()
+		if err = removeIfExists(filepath.Join(dir.path, h[:2], h[2:])); err != nil {
+			return err
+		}
+		// Remove the fan-out directory if it becomes empty.
+		os.Remove(filepath.Join(dir.path, h[:2]))
+	}
+	return nil
+}
+
+// rootObject is an object which must be kept with everything reachable from
+// it. Name is the ref name or the file which refers the object.
+type rootObject struct {
+	Name string
+	ID   SHA1
+}
+
+// rootObjects returns objects referred from refs, HEAD and private refs of each
+// worktree, reflogs and the index of each worktree.
+func (r *Repository) rootObjects() ([]rootObject, error) {
+	var roots []rootObject
+	refs, err := r.Refs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		roots = append(roots, rootObject{ref.Name, ref.SHA1})
+	}
+
+	dirs := []string{r.root, r.commonDir()}
+	worktrees, _ := filepath.Glob(filepath.Join(r.commonDir(), "worktrees", "*"))
+	seen := make(map[string]bool)
+	for _, dir := range append(dirs, worktrees...) {
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		private, err := r.worktreeRefs(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range private {
+			roots = append(roots, rootObject{r.refPathIn(dir, ref.Name), ref.SHA1})
+		}
+		head := filepath.Join(dir, "HEAD")
+		if b, err := ioutil.ReadFile(head); err == nil {
+			// A symbolic HEAD points a ref which is already collected, or
+			// a branch yet to be born.
+			if id, ok := parseHexID(string(bytes.TrimSpace(b))); ok {
+				roots = append(roots, rootObject{head, id})
+			}
+		}
+		index := filepath.Join(dir, "index")
+		ids, err := readIndexObjects(index, r.hash)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, id := range ids {
+			roots = append(roots, rootObject{index, id})
+		}
+
+		err = filepath.Walk(filepath.Join(dir, "logs"), func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			ids, err := readReflogIDs(path)
+			for _, id := range ids {
+				roots = append(roots, rootObject{path, id})
+			}
+			return err
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return roots, nil
+}
+
+// readReflogIDs returns the old and new object ids recorded in the reflog.
+func readReflogIDs(path string) ([]SHA1, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ids []SHA1
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		fields := strings.SplitN(scan.Text(), " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[:2] {
+			if id, ok := parseHexID(field); ok && !id.Empty() {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, scan.Err()
+}
+
+// parseHexID parses s as a full hex object id.
+func parseHexID(s string) (SHA1, bool) {
+	id, err := NewSHA1(s)
+	return id, err == nil
+}
+
+// looseObjectTimes returns the modification times of loose objects in the
+// objects directory.
+func looseObjectTimes(objects string) (map[SHA1]time.Time, error) {
+	times := make(map[SHA1]time.Time)
+	dirs, err := ioutil.ReadDir(objects)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(objects, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if id, ok := parseLooseObjectPath(dir.Name(), file.Name()); ok {
+				times[id] = file.ModTime()
+			}
+		}
+	}
+	return times, nil
+}
+
+// loosenObject writes a packed object as a loose object. The modification time
+// is set to mtime, so that the grace period is counted from it.
+func loosenObject(store *fsObjectStore, id SHA1, mtime time.Time) error {
+	entry, err := store.Entry(id)
+	if err != nil {
+		return err
+	}
+	defer entry.Close()
+	data, err := entry.ReadAll()
+	if err != nil {
+		return err
+	}
+	// Put would skip writing since the object is still in the pack.
+	_, path, err := store.writeObjectData(entry.Type(), bytes.NewReader(data))
+	if err == nil {
+		err = store.storeAsObject(path, id)
+	}
+	if err != nil {
+		if path != "" {
+			os.Remove(path)
+		}
+		return err
+	}
+	h := id.String()
+	return os.Chtimes(filepath.Join(store.path, h[:2], h[2:]), mtime, mtime)
+}
+
+func forEachPackObject(pack *Pack, fn func(id SHA1)) {
+	for i, n := 0, pack.idx.Len(); i < n; i++ {
+		fn(pack.idx.EntryAt(i).ID)
+	}
+}
+
+// removePack removes the pack and its auxiliary files. The index is removed
+// first so that no index points a missing pack.
+func removePack(pack *Pack) error {
+	base := pack.basePath()
+	for _, ext := range []string{".idx", ".pack", ".bitmap", ".rev"} {
+		if err := removeIfExists(base + ext); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
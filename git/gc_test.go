@@ -0,0 +1,210 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGC(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	ids := newTestObjects(t, repo, 5)
+	if err := repo.NewRef(BranchRef("master"), ids[len(ids)-1]).Write(); err != nil {
+		t.Fatal(err)
+	}
+	newBlob := func(s string, age time.Duration) SHA1 {
+		blob := repo.NewBlob(bytes.NewReader([]byte(s)))
+		if err := blob.Write(); err != nil {
+			t.Fatal(err)
+		}
+		h := blob.SHA1().String()
+		mtime := time.Now().Add(-age)
+		if err := os.Chtimes(filepath.Join(dir, "objects", h[:2], h[2:]), mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+		return blob.SHA1()
+	}
+	old := newBlob("old", 30*24*time.Hour)
+	recent := newBlob("recent", time.Hour)
+
+	packDir := filepath.Join(dir, "objects", "pack")
+	w := NewPackWriter(repo)
+	w.Add(ids[0])
+	keep, err := w.Save(packDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keep[:len(keep)-len(".pack")]+".keep", nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = repo.GC(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	packs, _ := filepath.Glob(filepath.Join(packDir, "*.pack"))
+	if len(packs) != 2 {
+		t.Fatalf("Unexpected packs: %v", packs)
+	}
+	loose, err := looseObjectTimes(filepath.Join(dir, "objects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loose[recent]; !ok || len(loose) != 1 {
+		t.Errorf("Unexpected loose objects: %v", loose)
+	}
+	if repo.ObjectStore().Has(old) {
+		t.Errorf("%s is not pruned", old)
+	}
+	for _, id := range ids {
+		if _, err = repo.Object(id); err != nil {
+			t.Errorf("%s: Unexpected error: %v", id, err)
+		}
+	}
+	for _, pack := range repo.store.(*fsObjectStore).dirs[0].packs {
+		if pack.path == keep && pack.idx.Len() != 1 {
+			t.Errorf("Kept pack is modified")
+		}
+	}
+
+	if err = repo.GC(&GCOptions{PruneNow: true}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if repo.ObjectStore().Has(recent) {
+		t.Errorf("%s is not pruned", recent)
+	}
+	packs, _ = filepath.Glob(filepath.Join(packDir, "*.pack"))
+	if len(packs) != 2 {
+		t.Fatalf("Unexpected packs: %v", packs)
+	}
+}
+
+// encodeIndex encodes an index v2 file with an entry of each mode and id, and
+// the cache tree extension whose root is tree.
+func encodeIndex(h *HashAlgo, modes []TreeEntryMode, ids []SHA1, tree SHA1) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteString("DIRC")
+	binary.Write(buf, binary.BigEndian, []uint32{2, uint32(len(ids))})
+	for i, id := range ids {
+		name := fmt.Sprintf("file%d", i)
+		stat := make([]uint32, 10)
+		stat[6] = uint32(modes[i])
+		binary.Write(buf, binary.BigEndian, stat)
+		buf.Write(id.Bytes())
+		binary.Write(buf, binary.BigEndian, uint16(len(name)))
+		buf.WriteString(name)
+		size := 40 + h.Size + 2 + len(name)
+		buf.Write(make([]byte, (size+8)&^7-size))
+	}
+	ext := fmt.Sprintf("%c%d 0\n", 0, len(ids))
+	buf.WriteString("TREE")
+	binary.Write(buf, binary.BigEndian, uint32(len(ext)+h.Size))
+	buf.WriteString(ext)
+	buf.Write(tree.Bytes())
+	hasher := h.New()
+	hasher.Write(buf.Bytes())
+	buf.Write(h.Sum(hasher).Bytes())
+	return buf.Bytes()
+}
+
+func TestGCIndex(t *testing.T) {
+	repo, dir := newTestRepo(t, false)
+	ids := newTestObjects(t, repo, 2)
+	if err := repo.NewRef(BranchRef("master"), ids[len(ids)-1]).Write(); err != nil {
+		t.Fatal(err)
+	}
+	staged := repo.NewBlob(bytes.NewReader([]byte("staged\n")))
+	if err := staged.Write(); err != nil {
+		t.Fatal(err)
+	}
+	tree := repo.NewTree()
+	tree.Add("staged", staged, ModeFile)
+	if err := tree.Write(); err != nil {
+		t.Fatal(err)
+	}
+	objects := filepath.Join(dir, ".git", "objects")
+	past := time.Now().Add(-30 * 24 * time.Hour)
+	loose, err := looseObjectTimes(objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := range loose {
+		h := id.String()
+		if err = os.Chtimes(filepath.Join(objects, h[:2], h[2:]), past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A submodule commit in the index is not in the repository.
+	submodule := SHA1FromBytes(bytes.Repeat([]byte{1}, 20))
+	modes := []TreeEntryMode{ModeFile, ModeGitlink}
+	index := encodeIndex(repo.hash, modes, []SHA1{staged.SHA1(), submodule}, tree.SHA1())
+	if err = ioutil.WriteFile(filepath.Join(dir, ".git", "index"), index, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.GC(&GCOptions{}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, id := range []SHA1{staged.SHA1(), tree.SHA1()} {
+		if !repo.ObjectStore().Has(id) {
+			t.Errorf("%s in the index is pruned", id)
+		}
+	}
+}
+
+func TestGCRefs(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	commitOf := func(n int) SHA1 {
+		ids := newTestObjects(t, repo, n)
+		return ids[len(ids)-1]
+	}
+	master, bisect, private, unreachable := commitOf(1), commitOf(2), commitOf(3), commitOf(4)
+	refs := map[string]SHA1{
+		filepath.Join(dir, "refs", "heads", "master"):                          master,
+		filepath.Join(dir, "worktrees", "wt", "refs", "bisect", "bad"):         bisect,
+		filepath.Join(dir, "refs", "worktree", "private"):                      private,
+		filepath.Join(dir, "refs", "heads", "master.lock"):                     SHA1{},
+		filepath.Join(dir, "worktrees", "wt", "refs", "worktree", "gone.lock"): SHA1{},
+	}
+	for path, id := range refs {
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(id.String()+"\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// GC fails without pruning anything if a ref is broken.
+	broken := filepath.Join(dir, "refs", "heads", "broken")
+	if err := ioutil.WriteFile(broken, []byte("broken\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.GC(&GCOptions{PruneNow: true}); err == nil {
+		t.Fatal("Expected error for a broken ref")
+	}
+	for _, id := range []SHA1{master, bisect, private, unreachable} {
+		if !repo.ObjectStore().Has(id) {
+			t.Errorf("%s is pruned", id)
+		}
+	}
+
+	if err := os.Remove(broken); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.GC(&GCOptions{PruneNow: true}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, id := range []SHA1{master, bisect, private} {
+		if !repo.ObjectStore().Has(id) {
+			t.Errorf("%s is pruned", id)
+		}
+	}
+	if repo.ObjectStore().Has(unreachable) {
+		t.Errorf("%s is not pruned", unreachable)
+	}
+}
@@ -0,0 +1,117 @@
+package git
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// maxHashSize is the size of the longest object id among hash algorithms.
+const maxHashSize = sha256.Size
+
+// HashAlgo is a hash algorithm to compute object ids. A repository uses SHA-1
+// unless extensions.objectFormat is set to sha256.
+type HashAlgo struct {
+	// Name is the name used in extensions.objectFormat.
+	Name string
+	// Size is the length of binary object ids.
+	Size int
+
+	// format is the index in hashAlgos, which is stored in ids.
+	format uint8
+	// version is the hash version in multi-pack-index and commit-graph.
+	version byte
+	newHash func() hash.Hash
+}
+
+var (
+	HashSHA1   = &HashAlgo{Name: "sha1", Size: sha1.Size, format: 0, version: 1, newHash: sha1.New}
+	HashSHA256 = &HashAlgo{Name: "sha256", Size: sha256.Size, format: 1, version: 2, newHash: sha256.New}
+)
+
+var hashAlgos = []*HashAlgo{HashSHA1, HashSHA256}
+
+// hashAlgoByName returns the algorithm named in extensions.objectFormat.
+func hashAlgoByName(name string) (*HashAlgo, error) {
+	for _, h := range hashAlgos {
+		if h.Name == name {
+			return h, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown object format: %s", name)
+}
+
+// hashAlgoByVersion returns the algorithm of the hash version in file formats
+// such as multi-pack-index.
+func hashAlgoByVersion(version byte) (*HashAlgo, error) {
+	for _, h := range hashAlgos {
+		if h.version == version {
+			return h, nil
+		}
+	}
+	return nil, ErrUnknownFormat
+}
+
+// hashAlgoBySize returns the algorithm whose ids are size bytes, or nil.
+func hashAlgoBySize(size int) *HashAlgo {
+	for _, h := range hashAlgos {
+		if h.Size == size {
+			return h
+		}
+	}
+	return nil
+}
+
+func (h *HashAlgo) String() string {
+	return h.Name
+}
+
+// New returns a new hash.Hash computing ids.
+func (h *HashAlgo) New() hash.Hash {
+	return h.newHash()
+}
+
+// Zero returns the id whose bytes are all zero.
+func (h *HashAlgo) Zero() (id SHA1) {
+	id.format = h.format
+	return
+}
+
+// FromBytes returns the id of binary form b. b is truncated or padded with
+// zeros to Size bytes.
+func (h *HashAlgo) FromBytes(b []byte) SHA1 {
+	id := h.Zero()
+	copy(id.hash[:h.Size], b)
+	return id
+}
+
+// Sum returns the id computed by hasher created by New.
+func (h *HashAlgo) Sum(hasher hash.Hash) SHA1 {
+	return h.FromBytes(hasher.Sum(nil))
+}
+
+// read reads a binary id from r.
+func (h *HashAlgo) read(r io.Reader) (SHA1, error) {
+	id := h.Zero()
+	err := id.Fill(r)
+	return id, err
+}
+
+// hashObject computes the object id of typ and data.
+func (h *HashAlgo) hashObject(typ string, data []byte) SHA1 {
+	hasher := h.New()
+	fmt.Fprintf(hasher, "%s %d%c", typ, len(data), 0)
+	hasher.Write(data)
+	return h.Sum(hasher)
+}
+
+// orSHA1 returns h, or SHA-1 if h is nil. It's used for the zero value of
+// types which have an algorithm.
+func (h *HashAlgo) orSHA1() *HashAlgo {
+	if h == nil {
+		return HashSHA1
+	}
+	return h
+}
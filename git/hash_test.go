@@ -0,0 +1,126 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewSHA1(t *testing.T) {
+	sha1Hex := "ce013625030ba8dba906f756967f9e9ca394464a"
+	sha256Hex := "6ef19b41225c5369f1c104d45d8d85efa9b057b53b14b4b9b939dd74decc5321"
+	for _, s := range []string{sha1Hex, sha256Hex} {
+		id, err := NewSHA1(s)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if id.String() != s || len(id.Bytes()) != len(s)/2 || id.Algo().Size != len(s)/2 {
+			t.Errorf("Unexpected id: %s", id)
+		}
+	}
+	if _, err := NewSHA1(sha1Hex[:38]); err != ErrInvalidSHA1 {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if SHA1FromHexString(sha1Hex) == HashSHA256.FromBytes(SHA1FromHexString(sha1Hex).Bytes()) {
+		t.Errorf("Ids of different algorithms must not be equal")
+	}
+}
+
+func TestSHA256Repository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, err := Init(dir, true, &InitOptions{ObjectFormat: HashSHA256})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	repo, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if repo.HashAlgo() != HashSHA256 {
+		t.Fatalf("Unexpected hash algorithm: %s", repo.HashAlgo())
+	}
+
+	blob := repo.NewBlob(bytes.NewReader(nil))
+	if err = blob.Write(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s := blob.SHA1().String(); s != "473a0f4c3be8a93681a267e3b1e9a7dcda1185436fe141f7749120a303721813" {
+		t.Fatalf("Unexpected blob id: %s", s)
+	}
+	ids := newTestObjects(t, repo, 5)
+	commit := ids[len(ids)-1]
+	c, err := repo.Commit(commit)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, _, err = c.Tree.FindBlob("dir/file4"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	w := NewPackWriter(repo)
+	w.Add(ids...)
+	path, err := w.Save(filepath.Join(dir, "objects", "pack"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err = repo.WriteCommitGraph([]SHA1{commit}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err = repo.WriteMultiPackIndex(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	repo, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	pack, err := openPack(path, HashSHA256)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer pack.Close()
+	if _, err = pack.Verify(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if pack.idx.Len() != len(ids) {
+		t.Fatalf("Unexpected number of objects: %d", pack.idx.Len())
+	}
+	for _, id := range ids {
+		if _, err = repo.Object(id); err != nil {
+			t.Errorf("%s: unexpected error: %v", id, err)
+		}
+	}
+	graph, err := repo.CommitGraph()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if e := graph.Lookup(commit); e == nil || e.Tree != c.Tree.SHA1() {
+		t.Errorf("Unexpected commit graph entry: %+v", e)
+	}
+	res, err := repo.Fsck()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !res.OK() {
+		t.Errorf("Unexpected fsck result: %+v", res)
+	}
+}
+
+func TestUnknownObjectFormat(t *testing.T) {
+	_, dir := newTestRepo(t, true)
+	config := "[core]\n\trepositoryformatversion = 1\n[extensions]\n\tobjectformat = md5\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "config"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dir); err == nil || !strings.Contains(err.Error(), "md5") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+)
+
+var indexMagic = []byte("DIRC")
+
+const indexFlagExtended = 0x4000
+
+// readIndexObjects returns ids of objects recorded in the index file, which
+// are blobs of entries, trees of the cache tree extension and blobs of the
+// resolve undo extension. Submodule commits are excluded. The shared index of
+// a split index is read together.
+func readIndexObjects(path string, h *HashAlgo) ([]SHA1, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 12+h.Size || !bytes.Equal(data[:4], indexMagic) {
+		return nil, ErrUnknownFormat
+	}
+	data, checksum := data[:len(data)-h.Size], h.FromBytes(data[len(data)-h.Size:])
+	// The checksum is zero if index.skipHash is enabled.
+	if checksum != h.Zero() {
+		hasher := h.New()
+		hasher.Write(data)
+		if h.Sum(hasher) != checksum {
+			return nil, ErrChecksum
+		}
+	}
+	version := binary.BigEndian.Uint32(data[4:])
+	if version < 2 || version > 4 {
+		return nil, ErrUnknownFormat
+	}
+
+	var ids []SHA1
+	n := binary.BigEndian.Uint32(data[8:])
+	pos := 12
+	for i := uint32(0); i < n; i++ {
+		// Stat data of 40 bytes including the mode precedes the id.
+		start := pos
+		pos += 40 + h.Size + 2
+		if pos > len(data) {
+			return nil, ErrUnknownFormat
+		}
+		mode := TreeEntryMode(binary.BigEndian.Uint32(data[start+24:]))
+		id := h.FromBytes(data[start+40 : start+40+h.Size])
+		if flags := binary.BigEndian.Uint16(data[pos-2:]); flags&indexFlagExtended != 0 {
+			pos += 2
+		}
+		if version == 4 {
+			// The path is prefix compressed, a varint of the length to
+			// strip from the previous path is followed by the rest.
+			for pos < len(data) && data[pos]&0x80 != 0 {
+				pos++
+			}
+			pos++
+		}
+		if pos > len(data) {
+			return nil, ErrUnknownFormat
+		}
+		end := bytes.IndexByte(data[pos:], 0)
+		if end < 0 {
+			return nil, ErrUnknownFormat
+		}
+		pos += end + 1
+		if version < 4 {
+			// Entries are padded with NULs to a multiple of 8 bytes.
+			pos = start + (pos-start+7)&^7
+		}
+		if mode != ModeGitlink {
+			ids = append(ids, id)
+		}
+	}
+
+	for pos+8 <= len(data) {
+		sig := string(data[pos : pos+4])
+		size := int(binary.BigEndian.Uint32(data[pos+4:]))
+		pos += 8
+		if size > len(data)-pos {
+			return nil, ErrUnknownFormat
+		}
+		ext := data[pos : pos+size]
+		pos += size
+
+		switch sig {
+		case "TREE":
+			tree, err := readCacheTree(ext, h)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, tree...)
+		case "REUC":
+			reuc, err := readResolveUndo(ext, h)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, reuc...)
+		case "link":
+			if len(ext) < h.Size {
+				return nil, ErrUnknownFormat
+			}
+			if shared := h.FromBytes(ext[:h.Size]); shared != h.Zero() {
+				name := filepath.Join(filepath.Dir(path), "sharedindex."+shared.String())
+				base, err := readIndexObjects(name, h)
+				if err != nil {
+					return nil, err
+				}
+				ids = append(ids, base...)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// readCacheTree reads the cache tree extension. Each entry is a path, the
+// number of index entries covered, the number of subtrees and the tree id.
+// The id is omitted if the number of entries is -1, i.e. it's invalidated.
+func readCacheTree(data []byte, h *HashAlgo) ([]SHA1, error) {
+	var ids []SHA1
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, 0)
+		if i < 0 {
+			return nil, ErrUnknownFormat
+		}
+		data = data[i+1:]
+		i = bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return nil, ErrUnknownFormat
+		}
+		fields := bytes.Fields(data[:i])
+		if len(fields) != 2 {
+			return nil, ErrUnknownFormat
+		}
+		count, err := strconv.Atoi(string(fields[0]))
+		if err != nil {
+			return nil, ErrUnknownFormat
+		}
+		data = data[i+1:]
+		if count < 0 {
+			continue
+		}
+		if len(data) < h.Size {
+			return nil, ErrUnknownFormat
+		}
+		ids = append(ids, h.FromBytes(data[:h.Size]))
+		data = data[h.Size:]
+	}
+	return ids, nil
+}
+
+// readResolveUndo reads the resolve undo extension. Each entry is a path and
+// octal modes of three stages, followed by ids of stages whose mode isn't 0.
+// Ids of submodule commits are excluded.
+func readResolveUndo(data []byte, h *HashAlgo) ([]SHA1, error) {
+	var ids []SHA1
+	for len(data) > 0 {
+		var modes [4]uint64
+		for i := range modes {
+			j := bytes.IndexByte(data, 0)
+			if j < 0 {
+				return nil, ErrUnknownFormat
+			}
+			// The first field is the path.
+			if i > 0 {
+				mode, err := strconv.ParseUint(string(data[:j]), 8, 32)
+				if err != nil {
+					return nil, ErrUnknownFormat
+				}
+				modes[i] = mode
+			}
+			data = data[j+1:]
+		}
+		for _, mode := range modes[1:] {
+			if mode == 0 {
+				continue
+			}
+			if len(data) < h.Size {
+				return nil, ErrUnknownFormat
+			}
+			if TreeEntryMode(mode) != ModeGitlink {
+				ids = append(ids, h.FromBytes(data[:h.Size]))
+			}
+			data = data[h.Size:]
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,453 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// IndexPack reads a pack stream from rd like git index-pack, and stores it into
+// objects/pack of the repository together with its index. Deltas are resolved
+// to compute object ids. If the pack is thin, i.e. it has REF_DELTA objects
+// whose bases are not in the pack, the bases are taken from the repository
+// and appended to the pack. It returns the path of the pack file.
+//
+// Only the pack is read from rd, data following the trailing checksum is left
+// unread if rd is a *bufio.Reader.
+func (r *Repository) IndexPack(rd io.Reader) (path string, err error) {
+	store, ok := r.store.(*fsObjectStore)
+	if !ok {
+		return "", ErrNotSupported
+	}
+	if err = store.open(); err != nil {
+		return
+	}
+	objectDir := store.dirs[0]
+	dir := filepath.Join(objectDir.path, "pack")
+	if err = os.MkdirAll(dir, 0777); err != nil {
+		return
+	}
+
+	f, err := ioutil.TempFile(dir, "tmp_pack_")
+	if err != nil {
+		return
+	}
+	tmpPack, tmpIdx := f.Name(), ""
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+		if err != nil {
+			for _, name := range []string{tmpPack, tmpIdx} {
+				if name != "" {
+					os.Remove(name)
+				}
+			}
+		}
+	}()
+
+	ip := &packIndexer{repo: r, hash: r.hash, f: f}
+	if err = ip.scan(rd); err != nil {
+		return
+	}
+	if err = ip.resolve(); err != nil {
+		return
+	}
+	checksum, err := ip.finish()
+	if err != nil {
+		return
+	}
+	err = f.Close()
+	f = nil
+	if err != nil {
+		return
+	}
+	if err = os.Chmod(tmpPack, 0444); err != nil {
+		return
+	}
+
+	idx := newPackIndexV2(ip.objects(), checksum)
+	if tmpIdx, err = writeTempFile(dir, "tmp_idx_", idx.Encode); err != nil {
+		return
+	}
+	if path, err = installPack(dir, tmpPack, tmpIdx, checksum); err != nil {
+		return
+	}
+	err = objectDir.openPack()
+	return
+}
+
+// indexEntry is an entry found in the pack stream.
+type indexEntry struct {
+	id     SHA1
+	typ    packEntryType
+	offset int64
+	crc    uint32
+	// dataOffset is the offset of the compressed data following headers.
+	dataOffset int64
+	// baseOffset is the offset of the base of OFS_DELTA, and baseID is the
+	// id of the base of REF_DELTA.
+	baseOffset int64
+	baseID     SHA1
+}
+
+func (e *indexEntry) isDelta() bool {
+	return e.typ == packEntryOfsDelta || e.typ == packEntryRefDelta
+}
+
+// packIndexer builds an index of a pack stream. The stream is copied to f
+// while scanning, then deltas are resolved reading the data back from f.
+type packIndexer struct {
+	repo    *Repository
+	hash    *HashAlgo
+	f       *os.File
+	entries []*indexEntry
+	// total is the number of entries in the stream. Bases appended for a
+	// thin pack follow them.
+	total int
+	// end is the end of entries, where the trailing checksum is written.
+	end      int64
+	checksum SHA1
+}
+
+// scan reads the pack stream, computing ids of undeltified objects and CRC32
+// of all entries. The stream is verified with its trailing checksum.
+func (ip *packIndexer) scan(rd io.Reader) error {
+	br, ok := rd.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(rd)
+	}
+	bw := bufio.NewWriter(ip.f)
+	s := &packScanner{r: br, w: bw, hash: ip.hash.New(), crc: crc32.NewIEEE()}
+
+	var header PackHeader
+	if err := binary.Read(s, binary.BigEndian, &header); err != nil {
+		return err
+	}
+	if header.Magic != packMagic || header.Version != 2 {
+		return ErrUnknownFormat
+	}
+
+	var zr io.ReadCloser
+	for i := uint32(0); i < header.Total; i++ {
+		e := &indexEntry{offset: s.n}
+		s.crc.Reset()
+		h, err := readPackEntryHeader(s)
+		if err != nil {
+			return err
+		}
+		e.typ = h[0].Type()
+		size := h[0].Size0()
+		for i, l := 0, len(h)-1; i < l; i++ {
+			size = (h[i+1].Size() << uint(4+7*i)) | size
+		}
+
+		switch e.typ {
+		case packEntryCommit, packEntryTree, packEntryBlob, packEntryTag:
+		case packEntryOfsDelta:
+			h, err := readPackEntryHeader(s)
+			if err != nil {
+				return err
+			}
+			ofs := h[0].Size()
+			for _, b := range h[1:] {
+				ofs = (ofs+1)<<7 + b.Size()
+			}
+			if ofs <= 0 || ofs > e.offset {
+				return ErrUnknownFormat
+			}
+			e.baseOffset = e.offset - ofs
+		case packEntryRefDelta:
+			if e.baseID, err = ip.hash.read(s); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("Unknown pack entry type: %d", e.typ)
+		}
+		e.dataOffset = s.n
+
+		if zr == nil {
+			if zr, err = zlib.NewReader(s); err != nil {
+				return err
+			}
+		} else if err = zr.(zlib.Resetter).Reset(s, nil); err != nil {
+			return err
+		}
+		w, hasher := ioutil.Discard, hash.Hash(nil)
+		if !e.isDelta() {
+			hasher = ip.hash.New()
+			fmt.Fprintf(hasher, "%s %d%c", e.typ, size, 0)
+			w = hasher
+		}
+		n, err := io.Copy(w, zr)
+		if err != nil {
+			return err
+		}
+		if n != size {
+			return ErrUnknownFormat
+		}
+		if hasher != nil {
+			e.id = ip.hash.Sum(hasher)
+		}
+		e.crc = s.crc.Sum32()
+		ip.entries = append(ip.entries, e)
+	}
+
+	// The trailing checksum is read directly, it's written by finish.
+	ip.checksum = ip.hash.Sum(s.hash)
+	checksum, err := ip.hash.read(br)
+	if err != nil {
+		return err
+	}
+	if checksum != ip.checksum {
+		return ErrChecksum
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	ip.total = len(ip.entries)
+	ip.end = s.n
+	return s.err
+}
+
+// resolve resolves deltas from their bases to compute ids of deltified
+// objects. Bases missing in the pack are appended from the repository.
+func (ip *packIndexer) resolve() error {
+	ofsChildren := make(map[int64][]*indexEntry)
+	refChildren := make(map[SHA1][]*indexEntry)
+	deltas := 0
+	for _, e := range ip.entries {
+		switch e.typ {
+		case packEntryOfsDelta:
+			ofsChildren[e.baseOffset] = append(ofsChildren[e.baseOffset], e)
+		case packEntryRefDelta:
+			refChildren[e.baseID] = append(refChildren[e.baseID], e)
+		default:
+			continue
+		}
+		deltas++
+	}
+
+	var walk func(base *indexEntry, typ string, data []byte) error
+	walk = func(base *indexEntry, typ string, data []byte) error {
+		children := append(ofsChildren[base.offset], refChildren[base.id]...)
+		delete(ofsChildren, base.offset)
+		delete(refChildren, base.id)
+		for _, e := range children {
+			delta, err := ip.readData(e)
+			if err != nil {
+				return err
+			}
+			buf, err := applyDelta(&memoryEntry{typ: typ, data: data}, delta)
+			if err != nil {
+				return err
+			}
+			e.id = ip.hash.hashObject(typ, buf.Bytes())
+			deltas--
+			err = walk(e, typ, buf.Bytes())
+			buf.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, e := range ip.entries {
+		if e.isDelta() || len(ofsChildren[e.offset])+len(refChildren[e.id]) == 0 {
+			continue
+		}
+		buf, err := ip.readData(e)
+		if err != nil {
+			return err
+		}
+		err = walk(e, e.typ.String(), buf.Bytes())
+		buf.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	// The rest of REF_DELTA are of a thin pack. A missing base may be an
+	// object in the pack deltified against another missing base, so bases
+	// are taken from the repository until no more delta is resolved. Bases
+	// are tried in the order of ids to make the resulting pack deterministic.
+	var external []SHA1
+	for resolved := true; resolved && len(refChildren) > 0; {
+		resolved = false
+		ids := make([]SHA1, 0, len(refChildren))
+		for id := range refChildren {
+			ids = append(ids, id)
+		}
+		sort.Sort(bySHA1(ids))
+		for _, id := range ids {
+			if _, ok := refChildren[id]; !ok {
+				continue
+			}
+			typ, data, err := ip.readBase(id)
+			if err == ErrObjectNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			// The base is not in the pack, so it has no OFS_DELTA child.
+			if err = walk(&indexEntry{id: id, offset: -1}, typ, data); err != nil {
+				return err
+			}
+			external = append(external, id)
+			resolved = true
+		}
+	}
+	if len(refChildren) > 0 {
+		return ErrObjectNotFound
+	}
+	if deltas != 0 {
+		return ErrUnknownFormat
+	}
+
+	// Only bases which don't turn out to be in the pack are appended.
+	inPack := make(map[SHA1]bool, len(ip.entries))
+	for _, e := range ip.entries {
+		inPack[e.id] = true
+	}
+	sort.Sort(bySHA1(external))
+	for _, id := range external {
+		if inPack[id] {
+			continue
+		}
+		typ, data, err := ip.readBase(id)
+		if err != nil {
+			return err
+		}
+		if err = ip.appendBase(id, typ, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readData reads the inflated data of an entry in the pack.
+func (ip *packIndexer) readData(e *indexEntry) (*bytesBuffer, error) {
+	zr, err := zlib.NewReader(bufio.NewReader(io.NewSectionReader(ip.f, e.dataOffset, ip.end-e.dataOffset)))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return newBytesBuffer(zr)
+}
+
+// readBase reads the object of id from the repository.
+func (ip *packIndexer) readBase(id SHA1) (typ string, data []byte, err error) {
+	entry, err := ip.repo.entry(id)
+	if err != nil {
+		return
+	}
+	defer entry.Close()
+	typ = entry.Type()
+	b, err := entry.ReadAll()
+	if err != nil {
+		return
+	}
+	// The data may be released by Close.
+	return typ, append([]byte(nil), b...), nil
+}
+
+// appendBase appends the object of id taken from the repository to the pack.
+func (ip *packIndexer) appendBase(id SHA1, typ string, data []byte) error {
+	t, err := packEntryTypeOf(typ)
+	if err != nil {
+		return err
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(encodePackEntryHeader(t, int64(len(data))))
+	if err = writeCompressed(buf, data); err != nil {
+		return err
+	}
+	if _, err = ip.f.WriteAt(buf.Bytes(), ip.end); err != nil {
+		return err
+	}
+	ip.entries = append(ip.entries, &indexEntry{
+		id:     id,
+		typ:    t,
+		offset: ip.end,
+		crc:    crc32.ChecksumIEEE(buf.Bytes()),
+	})
+	ip.end += int64(buf.Len())
+	return nil
+}
+
+// finish writes the trailing checksum and returns it. If bases are appended,
+// the number of objects in the header is updated and the whole pack is hashed
+// again.
+func (ip *packIndexer) finish() (SHA1, error) {
+	if len(ip.entries) > ip.total {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(len(ip.entries)))
+		if _, err := ip.f.WriteAt(b[:], 8); err != nil {
+			return SHA1{}, err
+		}
+		hasher := ip.hash.New()
+		if _, err := io.Copy(hasher, io.NewSectionReader(ip.f, 0, ip.end)); err != nil {
+			return SHA1{}, err
+		}
+		ip.checksum = ip.hash.Sum(hasher)
+	}
+	_, err := ip.f.WriteAt(ip.checksum.Bytes(), ip.end)
+	return ip.checksum, err
+}
+
+func (ip *packIndexer) objects() []packedObject {
+	objects := make([]packedObject, len(ip.entries))
+	for i, e := range ip.entries {
+		objects[i] = packedObject{e.id, e.offset, e.crc}
+	}
+	return objects
+}
+
+// packScanner reads a pack stream keeping track of the offset, the checksum of
+// the whole pack and CRC32 of the current entry. Read bytes are copied to w.
+// It reads bytes no more than requested, so that a zlib reader stops at the
+// end of the compressed data.
+type packScanner struct {
+	r    *bufio.Reader
+	w    io.Writer
+	n    int64
+	hash hash.Hash
+	crc  hash.Hash32
+	// err is the first error occurred in writing to w.
+	err     error
+	scratch [1]byte
+}
+
+func (s *packScanner) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.consume(p[:n])
+	return n, err
+}
+
+func (s *packScanner) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err == nil {
+		s.scratch[0] = b
+		s.consume(s.scratch[:])
+	}
+	return b, err
+}
+
+func (s *packScanner) consume(p []byte) {
+	if s.err == nil {
+		_, s.err = s.w.Write(p)
+	}
+	s.hash.Write(p)
+	s.crc.Write(p)
+	s.n += int64(len(p))
+}
@@ -0,0 +1,178 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestIndexPack(t *testing.T) {
+	src := NewRepository(NewMemoryObjectStore(nil), nil)
+	ids := newTestObjects(t, src, 20)
+	w := NewPackWriter(src)
+	w.Add(ids...)
+	buf := new(bytes.Buffer)
+	expected, err := w.Encode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, _ := newTestRepo(t, true)
+	path, err := repo.IndexPack(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	testPackObjects(t, src, path, ids)
+
+	pack, err := OpenPack(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer pack.Close()
+	idx := pack.idx.(*PackIndexV2)
+	if idx.PackFileHash != expected.PackFileHash {
+		t.Errorf("Pack checksum mismatch: %s != %s", idx.PackFileHash, expected.PackFileHash)
+	}
+	for i := range expected.Objects {
+		if idx.Objects[i] != expected.Objects[i] || idx.CRC32s[i] != expected.CRC32s[i] || idx.Offsets[i] != expected.Offsets[i] {
+			t.Errorf("Index entry mismatch: %s", expected.Objects[i])
+		}
+	}
+	for _, id := range ids {
+		if _, err = repo.Object(id); err != nil {
+			t.Errorf("%s: Unexpected error: %v", id, err)
+		}
+	}
+
+	buf.Bytes()[buf.Len()-1] ^= 0xff
+	if _, err = repo.IndexPack(buf); err != ErrChecksum {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestIndexThinPack(t *testing.T) {
+	repo, _ := newTestRepo(t, true)
+	base := bytes.Repeat([]byte("line of the base blob\n"), 100)
+	baseID, err := repo.writeObject("blob", bytes.NewReader(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := append(base[:len(base)/2:len(base)/2], "modified\n"...)
+	delta := createDelta(base, target)
+
+	// A pack which has only a REF_DELTA whose base is not in the pack.
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, &PackHeader{Magic: packMagic, Version: 2, Total: 1})
+	buf.Write(encodePackEntryHeader(packEntryRefDelta, int64(len(delta))))
+	buf.Write(baseID.Bytes())
+	writeCompressed(buf, delta)
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+
+	empty, _ := newTestRepo(t, true)
+	if _, err = empty.IndexPack(bytes.NewReader(buf.Bytes())); err != ErrObjectNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	path, err := repo.IndexPack(buf)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	pack, err := OpenPack(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer pack.Close()
+	if pack.Total != 2 || pack.idx.Len() != 2 {
+		t.Fatalf("Unexpected number of objects: %d", pack.Total)
+	}
+	if _, err = pack.Verify(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	id := HashSHA1.hashObject("blob", target)
+	if pack.idx.Entry(id) == nil || pack.idx.Entry(baseID) == nil {
+		t.Fatalf("Objects are not indexed")
+	}
+	blob, err := repo.Blob(id)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(blob.Data, target) {
+		t.Errorf("Unexpected data: %q", blob.Data)
+	}
+}
+
+func TestIndexThinPackChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// X is the base not in the pack, A is deltified against X and B is
+	// deltified against A. A sorts before X, so A must not be taken as a
+	// missing base.
+	base := bytes.Repeat([]byte("line of the base blob\n"), 100)
+	var a, b []byte
+	baseID := HashSHA1.hashObject("blob", base)
+	for i := 0; ; i++ {
+		a = append(base[:len(base):len(base)], fmt.Sprintf("a %d\n", i)...)
+		if HashSHA1.hashObject("blob", a).Compare(baseID) < 0 {
+			break
+		}
+	}
+	b = append(a[:len(a):len(a)], "b\n"...)
+	aID := HashSHA1.hashObject("blob", a)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, &PackHeader{Magic: packMagic, Version: 2, Total: 2})
+	for _, e := range []struct {
+		base     SHA1
+		src, dst []byte
+	}{{aID, a, b}, {baseID, base, a}} {
+		delta := createDelta(e.src, e.dst)
+		buf.Write(encodePackEntryHeader(packEntryRefDelta, int64(len(delta))))
+		buf.Write(e.base.Bytes())
+		writeCompressed(buf, delta)
+	}
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+
+	for i, objects := range [][][]byte{{base}, {base, a}} {
+		repo, err := Init(filepath.Join(dir, strconv.Itoa(i)), true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, data := range objects {
+			if _, err = repo.writeObject("blob", bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		path, err := repo.IndexPack(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		pack, err := OpenPack(path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if pack.Total != 3 || pack.idx.Len() != 3 {
+			t.Errorf("Unexpected number of objects: %d", pack.Total)
+		}
+		if _, err = pack.Verify(); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		for _, id := range []SHA1{baseID, aID, HashSHA1.hashObject("blob", b)} {
+			if pack.idx.Entry(id) == nil {
+				t.Errorf("%s is not indexed", id)
+			}
+		}
+		pack.Close()
+	}
+}
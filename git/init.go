@@ -0,0 +1,100 @@
+package git
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const defaultBranch = "master"
+
+const defaultDescription = "Unnamed repository; edit this file 'description' to name the repository.\n"
+
+type InitOptions struct {
+	// InitialBranch is the branch name HEAD points to. Default is master.
+	InitialBranch string
+	// Description is written to the description file if given.
+	Description string
+	// ObjectFormat is the hash algorithm of object ids. Default is HashSHA1.
+	ObjectFormat *HashAlgo
+}
+
+// Init creates a new repository at path and returns it. If bare is false, the
+// repository files are placed in .git directory under path. Like git command,
+// running Init on an existing repository is safe, it only creates missing
+// files and never overwrites existing ones.
+func Init(path string, bare bool, opts *InitOptions) (*Repository, error) {
+	if opts == nil {
+		opts = &InitOptions{}
+	}
+	branch := opts.InitialBranch
+	if branch == "" {
+		branch = defaultBranch
+	}
+	desc := opts.Description
+	if desc == "" {
+		desc = defaultDescription
+	}
+
+	path = filepath.Clean(path)
+	root := path
+	if !bare {
+		root = filepath.Join(path, ".git")
+	}
+
+	for _, dir := range []string{
+		filepath.Join("objects", "info"),
+		filepath.Join("objects", "pack"),
+		filepath.Join("refs", "heads"),
+		filepath.Join("refs", "tags"),
+		"info",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0777); err != nil {
+			return nil, err
+		}
+	}
+
+	files := []struct {
+		name string
+		data string
+	}{
+		{"HEAD", fmt.Sprintf("ref: %s\n", BranchRef(branch))},
+		{"config", defaultConfig(bare, opts.ObjectFormat.orSHA1())},
+		{"description", desc},
+	}
+	for _, file := range files {
+		if err := createFile(filepath.Join(root, file.name), []byte(file.data)); err != nil {
+			return nil, err
+		}
+	}
+
+	return newRepository(path, root, bare)
+}
+
+// defaultConfig returns the initial config. Only SHA-1 repositories can be
+// read by old versions, so the format version 1 is used only for others.
+func defaultConfig(bare bool, hash *HashAlgo) string {
+	version := 0
+	if hash != HashSHA1 {
+		version = 1
+	}
+	s := fmt.Sprintf("[core]\n\trepositoryformatversion = %d\n\tfilemode = true\n\tbare = %t\n", version, bare)
+	if !bare {
+		s += "\tlogallrefupdates = true\n"
+	}
+	if hash != HashSHA1 {
+		s += fmt.Sprintf("[extensions]\n\tobjectformat = %s\n", hash.Name)
+	}
+	return s
+}
+
+// createFile writes data to path only if the file doesn't exist yet.
+func createFile(path string, data []byte) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0666)
+}
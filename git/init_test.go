@@ -0,0 +1,83 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo, err := Init(dir, false, &InitOptions{InitialBranch: "main"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if repo.Bare || repo.root != filepath.Join(dir, ".git") {
+		t.Fatalf("Unexpected repository: %+v", repo)
+	}
+
+	blob := repo.NewBlob(bytes.NewReader([]byte("hello\n")))
+	if err = blob.Write(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s := blob.SHA1().String(); s != "ce013625030ba8dba906f756967f9e9ca394464a" {
+		t.Fatalf("Unexpected blob id: %s", s)
+	}
+	tree := repo.NewTree()
+	tree.Add("hello.txt", blob, ModeFile)
+	user := NewUser("go-git", "go-git@example.com")
+	commit := repo.NewCommit(tree, nil, user, user, "init\n")
+	if err = tree.Write(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err = commit.Write(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err = repo.NewRef(BranchRef("main"), commit.SHA1()).Write(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	repo, err = Open(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if head.SHA1 != commit.SHA1() {
+		t.Fatalf("HEAD mismatch: %s != %s", head.SHA1, commit.SHA1())
+	}
+	c, err := repo.Commit(head.SHA1)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	b, _, err := c.Tree.FindBlob("hello.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(b.Data) != "hello\n" {
+		t.Fatalf("Unexpected blob data: %q", b.Data)
+	}
+}
+
+func TestInitBare(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	if !repo.Bare || repo.root != dir {
+		t.Fatalf("Unexpected repository: %+v", repo)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "HEAD"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s := string(b); s != "ref: refs/heads/master\n" {
+		t.Fatalf("Unexpected HEAD: %q", s)
+	}
+}
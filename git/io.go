@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"compress/zlib"
+	"errors"
 	"io"
 	"io/ioutil"
 	"os"
@@ -11,12 +12,17 @@ import (
 	"github.com/edsrzf/mmap-go"
 )
 
+var errInvalidSeek = errors.New("Seek out of range")
+
 type packReader interface {
 	Read([]byte) (int, error)
 	ReadByte() (byte, error)
 	Seek(int64, int) (int64, error)
 	Offset() int64
 	ZlibReader() (io.ReadCloser, error)
+	// NewReader returns a reader from offset which is independent of the
+	// position of the packReader.
+	NewReader(offset int64) io.Reader
 	Close() error
 }
 
@@ -50,11 +56,20 @@ func (r *stdPackReader) ReadByte() (byte, error) {
 }
 
 func (r *stdPackReader) Seek(offset int64, whence int) (n int64, err error) {
+	if whence == os.SEEK_CUR {
+		// The file position is ahead of the logical one by buffered bytes.
+		var pos int64
+		if pos, err = r.f.Seek(0, os.SEEK_CUR); err != nil {
+			return
+		}
+		offset += pos - int64(r.br.Buffered())
+		whence = os.SEEK_SET
+	}
 	if n, err = r.f.Seek(offset, whence); err != nil {
 		return
 	}
 	r.br.Reset(r.f)
-	r.offset = offset
+	r.offset = n
 	return
 }
 
@@ -77,6 +92,14 @@ func (r *stdPackReader) ZlibReader() (io.ReadCloser, error) {
 	return ioutil.NopCloser(r.zr), nil
 }
 
+func (r *stdPackReader) NewReader(offset int64) io.Reader {
+	fi, err := r.f.Stat()
+	if err != nil {
+		return &errReader{err}
+	}
+	return bufio.NewReader(io.NewSectionReader(r.f, offset, fi.Size()-offset))
+}
+
 func (r *stdPackReader) Close() error {
 	if r.zr != nil {
 		r.zr.Close()
@@ -133,9 +156,14 @@ func (r *mmapPackReader) Seek(offset int64, whence int) (int64, error) {
 		pos = r.pos + offset
 	case os.SEEK_END:
 		pos = r.size + offset
+	default:
+		return 0, errInvalidSeek
+	}
+	if pos < 0 || pos > r.size {
+		return 0, errInvalidSeek
 	}
 	r.pos = pos
-	r.offset = offset
+	r.offset = pos
 	return pos, nil
 }
 
@@ -159,6 +187,13 @@ func (r *mmapPackReader) ZlibReader() (io.ReadCloser, error) {
 	return ioutil.NopCloser(r.zr), nil
 }
 
+func (r *mmapPackReader) NewReader(offset int64) io.Reader {
+	if offset < 0 || offset > r.size {
+		return &errReader{errInvalidSeek}
+	}
+	return bytes.NewReader(r.mm[offset:])
+}
+
 func (r *mmapPackReader) Close() error {
 	if r.zr != nil {
 		r.zr.Close()
@@ -166,3 +201,11 @@ func (r *mmapPackReader) Close() error {
 	r.mm.Unmap()
 	return r.f.Close()
 }
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
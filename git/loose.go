@@ -6,23 +6,27 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 type looseObjectEntry struct {
-	f   *os.File
-	zr  io.ReadCloser
-	br  *bufio.Reader
-	typ string
-	buf *bytesBuffer
+	f    *os.File
+	zr   io.ReadCloser
+	br   *bufio.Reader
+	typ  string
+	size int64
+	buf  *bytesBuffer
 }
 
-func newLooseObjectEntry(root string, id SHA1) (*looseObjectEntry, error) {
+func newLooseObjectEntry(objects string, id SHA1) (*looseObjectEntry, error) {
 	s := id.String()
-	path := filepath.Join(root, "objects", s[:2], s[2:])
+	path := filepath.Join(objects, s[:2], s[2:])
 
 	e := new(looseObjectEntry)
 	file, err := os.Open(path)
-	if err != nil {
+	if os.IsNotExist(err) {
+		return nil, ErrObjectNotFound
+	} else if err != nil {
 		return nil, err
 	}
 	e.f = file
@@ -42,13 +46,29 @@ func newLooseObjectEntry(root string, id SHA1) (*looseObjectEntry, error) {
 	}
 	e.typ = string(bs[:len(bs)-1])
 
-	if _, err = e.br.ReadBytes(0); err != nil {
+	if bs, err = e.br.ReadBytes(0); err != nil {
+		e.Close()
+		return nil, err
+	}
+	if e.size, err = strconv.ParseInt(string(bs[:len(bs)-1]), 10, 64); err != nil {
 		e.Close()
 		return nil, err
 	}
 	return e, nil
 }
 
+// statLooseObject returns the type and size of the loose object reading only
+// its header.
+func statLooseObject(objects string, id SHA1) (string, int64, error) {
+	e, err := newLooseObjectEntry(objects, id)
+	if err != nil {
+		return "", 0, err
+	}
+	e.zr.Close()
+	e.f.Close()
+	return e.typ, e.size, nil
+}
+
 func (e *looseObjectEntry) Type() string {
 	return e.typ
 }
@@ -63,6 +83,21 @@ func (e *looseObjectEntry) ReadAll() ([]byte, error) {
 	return e.buf.Bytes(), nil
 }
 
+// Reader returns a reader which inflates the object on the fly. ReadAll must
+// not be called with it.
+func (e *looseObjectEntry) Reader() (*ObjectReader, error) {
+	return &ObjectReader{
+		Type:   e.typ,
+		Size:   e.size,
+		r:      e.br,
+		remain: e.size,
+		closer: closerFunc(func() error {
+			e.zr.Close()
+			return e.f.Close()
+		}),
+	}, nil
+}
+
 func (e *looseObjectEntry) Close() (err error) {
 	if e.buf != nil {
 		err = e.buf.Close()
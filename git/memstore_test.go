@@ -0,0 +1,85 @@
+package git
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMemoryObjectStore(t *testing.T) {
+	store := NewMemoryObjectStore(nil)
+	repo := NewRepository(store, nil)
+
+	blob := repo.NewBlob(bytes.NewReader([]byte("hello\n")))
+	tree := repo.NewTree()
+	tree.Add("dir/hello.txt", blob, ModeFile)
+	if err := tree.Write(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	user := NewUser("go-git", "go-git@example.com")
+	commit := repo.NewCommit(tree, nil, user, user, "init\n")
+	if err := commit.Write(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s := blob.SHA1().String(); s != "ce013625030ba8dba906f756967f9e9ca394464a" {
+		t.Fatalf("Unexpected blob id: %s", s)
+	}
+	if n := store.Len(); n != 4 {
+		t.Fatalf("Unexpected number of objects: %d", n)
+	}
+	if !store.Has(commit.SHA1()) {
+		t.Fatalf("Commit not found: %s", commit.SHA1())
+	}
+
+	c, err := repo.Commit(commit.SHA1())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	b, _, err := c.Tree.FindBlob("dir/hello.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(b.Data) != "hello\n" {
+		t.Fatalf("Unexpected blob data: %q", b.Data)
+	}
+
+	var n int
+	store.ForEach(func(id SHA1) error {
+		n++
+		return nil
+	})
+	if n != 4 {
+		t.Fatalf("Unexpected number of iterated objects: %d", n)
+	}
+	if _, err = repo.Object(SHA1FromBytes([]byte{1})); err != ErrObjectNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestMemoryObjectStoreSHA256(t *testing.T) {
+	store := NewMemoryObjectStore(HashSHA256)
+	repo := NewRepository(store, HashSHA256)
+	if h := repo.HashAlgo(); h != HashSHA256 {
+		t.Fatalf("Unexpected hash algorithm: %s", h.Name)
+	}
+
+	blob := repo.NewBlob(bytes.NewReader([]byte("hello\n")))
+	tree := repo.NewTree()
+	tree.Add("hello.txt", blob, ModeFile)
+	if err := tree.Write(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if s := blob.SHA1().String(); s != "2cf8d83d9ee29543b34a87727421fdecb7e3f3a183d337639025de576db9ebb4" {
+		t.Fatalf("Unexpected blob id: %s", s)
+	}
+	tr, err := repo.Tree(tree.SHA1())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	b, _, err := tr.FindBlob("hello.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if b.SHA1() != blob.SHA1() || string(b.Data) != "hello\n" {
+		t.Fatalf("Unexpected blob: %s %q", b.SHA1(), b.Data)
+	}
+}
@@ -0,0 +1,293 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var midxMagic = [4]byte{'M', 'I', 'D', 'X'}
+
+var (
+	midxChunkPackNames    = chunkID{'P', 'N', 'A', 'M'}
+	midxChunkOIDFanout    = chunkID{'O', 'I', 'D', 'F'}
+	midxChunkOIDLookup    = chunkID{'O', 'I', 'D', 'L'}
+	midxChunkOffsets      = chunkID{'O', 'O', 'F', 'F'}
+	midxChunkLargeOffsets = chunkID{'L', 'O', 'F', 'F'}
+)
+
+var ErrNotSupported = errors.New("Not supported by the object store")
+
+const multiPackIndexName = "multi-pack-index"
+
+type MultiPackIndexHeader struct {
+	Magic      [4]byte
+	Version    byte
+	OIDVersion byte
+	Chunks     byte
+	BaseFiles  byte
+	Packs      uint32
+}
+
+// MultiPackIndex is an index over multiple packs in the same directory, stored
+// in objects/pack/multi-pack-index. It allows to find an object with a single
+// lookup instead of probing the index of each pack.
+type MultiPackIndex struct {
+	MultiPackIndexHeader
+	PackNames    []string
+	Fanout       [256]uint32
+	Objects      []SHA1
+	hash         *HashAlgo
+	packIDs      []uint32
+	offsets      []uint32
+	largeOffsets []uint64
+}
+
+type MultiPackIndexEntry struct {
+	ID SHA1
+	// PackName is the file name of the pack index which has the object.
+	PackName string
+	Offset   int64
+	pack     int
+}
+
+func OpenMultiPackIndex(path string) (*MultiPackIndex, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	midx := new(MultiPackIndex)
+	err = midx.Parse(b)
+	return midx, err
+}
+
+func (m *MultiPackIndex) Parse(b []byte) error {
+	err := binary.Read(bytes.NewReader(b), binary.BigEndian, &m.MultiPackIndexHeader)
+	if err != nil {
+		return err
+	}
+	if m.Magic != midxMagic || m.Version != 1 || m.BaseFiles != 0 {
+		return ErrUnknownFormat
+	}
+	if m.hash, err = hashAlgoByVersion(m.OIDVersion); err != nil {
+		return err
+	}
+	if b, err = verifyTrailingChecksum(b, m.hash); err != nil {
+		return err
+	}
+	headerLen := binary.Size(m.MultiPackIndexHeader)
+	chunks, err := readChunks(b[headerLen:], headerLen, int(m.Chunks))
+	if err != nil {
+		return err
+	}
+
+	names := bytes.Split(chunks[midxChunkPackNames], []byte{0})
+	for _, name := range names {
+		if len(name) > 0 {
+			m.PackNames = append(m.PackNames, string(name))
+		}
+	}
+	if len(m.PackNames) != int(m.Packs) {
+		return ErrUnknownFormat
+	}
+
+	if m.Fanout, m.Objects, err = readOIDChunks(chunks[midxChunkOIDFanout], chunks[midxChunkOIDLookup], m.hash); err != nil {
+		return err
+	}
+	offsets := chunks[midxChunkOffsets]
+	if len(offsets) != len(m.Objects)*8 {
+		return ErrUnknownFormat
+	}
+	m.packIDs = make([]uint32, len(m.Objects))
+	m.offsets = make([]uint32, len(m.Objects))
+	for i := range m.Objects {
+		m.packIDs[i] = binary.BigEndian.Uint32(offsets[i*8:])
+		m.offsets[i] = binary.BigEndian.Uint32(offsets[i*8+4:])
+		if m.packIDs[i] >= m.Packs {
+			return ErrUnknownFormat
+		}
+	}
+	large := chunks[midxChunkLargeOffsets]
+	m.largeOffsets = make([]uint64, len(large)/8)
+	for i := range m.largeOffsets {
+		m.largeOffsets[i] = binary.BigEndian.Uint64(large[i*8:])
+	}
+	for _, offset := range m.offsets {
+		if offset&packLargeOffset != 0 && int(offset&^packLargeOffset) >= len(m.largeOffsets) {
+			return ErrUnknownFormat
+		}
+	}
+	return nil
+}
+
+func (m *MultiPackIndex) Entry(id SHA1) *MultiPackIndexEntry {
+	x := searchFanout(&m.Fanout, m.Objects, id)
+	if x < 0 {
+		return nil
+	}
+	return m.EntryAt(x)
+}
+
+func (m *MultiPackIndex) EntryAt(i int) *MultiPackIndexEntry {
+	offset := int64(m.offsets[i])
+	if m.offsets[i]&packLargeOffset != 0 {
+		offset = int64(m.largeOffsets[m.offsets[i]&^packLargeOffset])
+	}
+	return &MultiPackIndexEntry{
+		ID:       m.Objects[i],
+		PackName: m.PackNames[m.packIDs[i]],
+		Offset:   offset,
+		pack:     int(m.packIDs[i]),
+	}
+}
+
+func (m *MultiPackIndex) Len() int {
+	return len(m.Objects)
+}
+
+// Encode writes the multi-pack-index in the format read by Parse.
+func (m *MultiPackIndex) Encode(w io.Writer) error {
+	names := new(bytes.Buffer)
+	for _, name := range m.PackNames {
+		names.WriteString(name)
+		names.WriteByte(0)
+	}
+	for names.Len()%4 != 0 {
+		names.WriteByte(0)
+	}
+
+	fanout, oids := oidChunks(m.Objects)
+	offsets := make([]byte, len(m.Objects)*8)
+	for i := range m.Objects {
+		binary.BigEndian.PutUint32(offsets[i*8:], m.packIDs[i])
+		binary.BigEndian.PutUint32(offsets[i*8+4:], m.offsets[i])
+	}
+	chunks := []chunk{
+		{midxChunkPackNames, names.Bytes()},
+		{midxChunkOIDFanout, fanout},
+		{midxChunkOIDLookup, oids},
+		{midxChunkOffsets, offsets},
+	}
+	if len(m.largeOffsets) > 0 {
+		large := make([]byte, len(m.largeOffsets)*8)
+		for i, offset := range m.largeOffsets {
+			binary.BigEndian.PutUint64(large[i*8:], offset)
+		}
+		chunks = append(chunks, chunk{midxChunkLargeOffsets, large})
+	}
+
+	m.MultiPackIndexHeader = MultiPackIndexHeader{
+		Magic:      midxMagic,
+		Version:    1,
+		OIDVersion: m.hash.orSHA1().version,
+		Chunks:     byte(len(chunks)),
+		Packs:      uint32(len(m.PackNames)),
+	}
+	header := new(bytes.Buffer)
+	binary.Write(header, binary.BigEndian, &m.MultiPackIndexHeader)
+	return writeChunkFile(w, header.Bytes(), chunks, m.hash.orSHA1())
+}
+
+// newMultiPackIndex builds a multi-pack-index of packs. If an object is stored
+// in several packs, the most recently modified pack is preferred.
+func newMultiPackIndex(packs []*Pack, hash *HashAlgo) (*MultiPackIndex, error) {
+	sorted := make([]*Pack, len(packs))
+	copy(sorted, packs)
+	sort.Sort(byPackName(sorted))
+
+	m := &MultiPackIndex{hash: hash}
+	mtimes := make([]int64, len(sorted))
+	for i, pack := range sorted {
+		fi, err := os.Stat(pack.path)
+		if err != nil {
+			return nil, err
+		}
+		mtimes[i] = fi.ModTime().UnixNano()
+		m.PackNames = append(m.PackNames, pack.indexName())
+	}
+
+	type location struct {
+		pack   uint32
+		offset int64
+	}
+	objects := make(map[SHA1]location)
+	for i, pack := range sorted {
+		for j, n := 0, pack.idx.Len(); j < n; j++ {
+			entry := pack.idx.EntryAt(j)
+			if loc, ok := objects[entry.ID]; ok && mtimes[loc.pack] >= mtimes[i] {
+				continue
+			}
+			objects[entry.ID] = location{uint32(i), entry.Offset}
+		}
+	}
+
+	m.Objects = make([]SHA1, 0, len(objects))
+	for id := range objects {
+		m.Objects = append(m.Objects, id)
+	}
+	sort.Sort(bySHA1(m.Objects))
+	for _, id := range m.Objects {
+		loc := objects[id]
+		m.Fanout[id.hash[0]]++
+		m.packIDs = append(m.packIDs, loc.pack)
+		if loc.offset < packLargeOffset {
+			m.offsets = append(m.offsets, uint32(loc.offset))
+		} else {
+			m.offsets = append(m.offsets, packLargeOffset|uint32(len(m.largeOffsets)))
+			m.largeOffsets = append(m.largeOffsets, uint64(loc.offset))
+		}
+	}
+	for i := 1; i < len(m.Fanout); i++ {
+		m.Fanout[i] += m.Fanout[i-1]
+	}
+	return m, nil
+}
+
+// WriteMultiPackIndex writes objects/pack/multi-pack-index which covers all
+// packs in the repository, and starts to use it for object lookup.
+func (r *Repository) WriteMultiPackIndex() error {
+	store, ok := r.store.(*fsObjectStore)
+	if !ok {
+		return ErrNotSupported
+	}
+	if err := store.open(); err != nil {
+		return err
+	}
+	dir := store.dirs[0]
+	if err := dir.openPack(); err != nil {
+		return err
+	}
+	midx, err := newMultiPackIndex(dir.packs, r.hash)
+	if err != nil {
+		return err
+	}
+
+	packDir := filepath.Join(dir.path, "pack")
+	tmp, err := writeTempFile(packDir, "tmp_midx_", midx.Encode)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filepath.Join(packDir, multiPackIndexName)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	dir.useMultiPackIndex(midx)
+	return nil
+}
+
+type byPackName []*Pack
+
+func (z byPackName) Len() int           { return len(z) }
+func (z byPackName) Swap(i, j int)      { z[i], z[j] = z[j], z[i] }
+func (z byPackName) Less(i, j int) bool { return z[i].indexName() < z[j].indexName() }
+
+type bySHA1 []SHA1
+
+func (z bySHA1) Len() int           { return len(z) }
+func (z bySHA1) Swap(i, j int)      { z[i], z[j] = z[j], z[i] }
+func (z bySHA1) Less(i, j int) bool { return z[i].Compare(z[j]) < 0 }
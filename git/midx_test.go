@@ -0,0 +1,59 @@
+package git
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestMultiPackIndex(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	ids := newTestObjects(t, repo, 10)
+	packDir := filepath.Join(dir, "objects", "pack")
+	for _, part := range [][]SHA1{ids[:5], ids[3:]} {
+		w := NewPackWriter(repo)
+		w.Add(part...)
+		if _, err := w.Save(packDir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.WriteMultiPackIndex(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	midx, err := OpenMultiPackIndex(filepath.Join(packDir, multiPackIndexName))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(midx.PackNames) != 2 || midx.Len() != len(ids) {
+		t.Fatalf("Unexpected multi-pack-index: %d packs, %d objects", len(midx.PackNames), midx.Len())
+	}
+
+	// Large offsets survive the round trip.
+	midx.offsets[0] = packLargeOffset
+	midx.largeOffsets = []uint64{1 << 33}
+	buf := new(bytes.Buffer)
+	if err = midx.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	parsed := new(MultiPackIndex)
+	if err = parsed.Parse(buf.Bytes()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if entry := parsed.EntryAt(0); entry.Offset != 1<<33 {
+		t.Fatalf("Unexpected offset: %d", entry.Offset)
+	}
+
+	repo, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if _, err = repo.Object(id); err != nil {
+			t.Errorf("%s: Unexpected error: %v", id, err)
+		}
+	}
+	if d := repo.store.(*fsObjectStore).dirs[0]; d.midx == nil || len(d.unindexed) != 0 {
+		t.Errorf("multi-pack-index is not used")
+	}
+}
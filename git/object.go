@@ -1,6 +1,7 @@
 package git
 
 import (
+	"bytes"
 	"errors"
 	"io"
 )
@@ -41,12 +42,73 @@ func objectType(obj Object) (string, error) {
 	return "", errors.New("Unknown object")
 }
 
-type objectEntry interface {
+// ObjectEntry is a raw object stored in an ObjectStore. Close must be called
+// after use.
+type ObjectEntry interface {
 	Type() string
 	ReadAll() ([]byte, error)
 	Close() error
 }
 
+// objectStreamer is implemented by an ObjectEntry which can read its content
+// as a stream without holding the whole content in memory.
+type objectStreamer interface {
+	Reader() (*ObjectReader, error)
+}
+
+// ObjectReader reads the content of an object. Type and Size are available
+// before reading. Close must be called after use.
+type ObjectReader struct {
+	Type   string
+	Size   int64
+	r      io.Reader
+	remain int64
+	closer io.Closer
+}
+
+func newObjectReader(entry ObjectEntry) (*ObjectReader, error) {
+	if s, ok := entry.(objectStreamer); ok {
+		return s.Reader()
+	}
+	data, err := entry.ReadAll()
+	if err != nil {
+		entry.Close()
+		return nil, err
+	}
+	return &ObjectReader{
+		Type:   entry.Type(),
+		Size:   int64(len(data)),
+		r:      bytes.NewReader(data),
+		remain: int64(len(data)),
+		closer: entry,
+	}, nil
+}
+
+func (o *ObjectReader) Read(p []byte) (int, error) {
+	if o.remain <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > o.remain {
+		p = p[:o.remain]
+	}
+	n, err := o.r.Read(p)
+	o.remain -= int64(n)
+	if err == io.EOF && o.remain > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (o *ObjectReader) Close() error {
+	return o.closer.Close()
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
 type SparseObject struct {
 	id   SHA1
 	obj  Object
@@ -0,0 +1,81 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestObjectReader(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	var contents [][]byte
+	var ids []SHA1
+	for i := 0; i < 2; i++ {
+		b := new(bytes.Buffer)
+		for j := 0; j < 50000; j++ {
+			fmt.Fprintf(b, "line %d\n", j*(i+1))
+		}
+		blob := repo.NewBlob(bytes.NewReader(b.Bytes()))
+		if err := blob.Write(); err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, b.Bytes())
+		ids = append(ids, blob.SHA1())
+	}
+
+	check := func(repo *Repository, name string) {
+		for i, id := range ids {
+			o, err := newBlob(id, repo).Reader()
+			if err != nil {
+				t.Fatalf("%s: Unexpected error: %v", name, err)
+			}
+			if o.Type != "blob" || o.Size != int64(len(contents[i])) {
+				t.Errorf("%s: Unexpected header: %s %d", name, o.Type, o.Size)
+			}
+			b, err := ioutil.ReadAll(o)
+			o.Close()
+			if err != nil {
+				t.Fatalf("%s: Unexpected error: %v", name, err)
+			}
+			if !bytes.Equal(b, contents[i]) {
+				t.Errorf("%s: Content mismatch", name)
+			}
+		}
+	}
+	check(repo, "loose")
+
+	w := NewPackWriter(repo)
+	w.Add(ids...)
+	if _, err := w.Save(filepath.Join(dir, "objects", "pack")); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		h := id.String()
+		os.Remove(filepath.Join(dir, "objects", h[:2], h[2:]))
+	}
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(repo, "packed")
+
+	mem := NewRepository(NewMemoryObjectStore(nil), nil)
+	for _, b := range contents {
+		if err = mem.NewBlob(bytes.NewReader(b)).Write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(mem, "memory")
+
+	user := NewUser("go-git", "go-git@example.com")
+	commit := mem.NewCommit(mem.NewTree(), nil, user, user, "")
+	if err := commit.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newBlob(commit.SHA1(), mem).Reader(); err != ErrTypeMismatch {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,275 @@
+package git
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxAlternateDepth is the limit of nested alternates same as git.
+const maxAlternateDepth = 5
+
+// objectDir is a directory which stores loose objects and packs, either the
+// repository's own objects directory or an alternate one.
+type objectDir struct {
+	path  string
+	hash  *HashAlgo
+	packs []*Pack
+
+	// midx is the multi-pack-index of this directory. Packs not covered by it
+	// are kept in unindexed and looked up one by one.
+	midx      *MultiPackIndex
+	midxPacks []*Pack
+	unindexed []*Pack
+}
+
+func newObjectDir(path string, hash *HashAlgo) (*objectDir, error) {
+	dir := &objectDir{path: path, hash: hash}
+	if err := dir.openPack(); err != nil {
+		return nil, err
+	}
+	return dir, nil
+}
+
+func (d *objectDir) entry(id SHA1) (ObjectEntry, error) {
+	if d.midx != nil {
+		if e := d.midx.Entry(id); e != nil {
+			return d.midxPacks[e.pack].entryAt(e.Offset)
+		}
+	}
+	for _, pack := range d.unindexed {
+		if entry, err := pack.entry(id); err == nil {
+			return entry, err
+		}
+	}
+	return newLooseObjectEntry(d.path, id)
+}
+
+func (d *objectDir) stat(id SHA1) (string, int64, error) {
+	if d.midx != nil {
+		if e := d.midx.Entry(id); e != nil {
+			return d.midxPacks[e.pack].stat(e.Offset)
+		}
+	}
+	for _, pack := range d.unindexed {
+		if e := pack.idx.Entry(id); e != nil {
+			return pack.stat(e.Offset)
+		}
+	}
+	return statLooseObject(d.path, id)
+}
+
+func (d *objectDir) has(id SHA1) bool {
+	if d.packOf(id) != nil {
+		return true
+	}
+	h := id.String()
+	_, err := os.Stat(filepath.Join(d.path, h[:2], h[2:]))
+	return err == nil
+}
+
+// packOf returns the pack which contains the object of id, or nil if no pack
+// contains it.
+func (d *objectDir) packOf(id SHA1) *Pack {
+	if d.midx != nil {
+		if e := d.midx.Entry(id); e != nil {
+			return d.midxPacks[e.pack]
+		}
+	}
+	for _, pack := range d.unindexed {
+		if pack.idx.Entry(id) != nil {
+			return pack
+		}
+	}
+	return nil
+}
+
+// forEach calls fn for each object in packs and loose objects. The same
+// object may be passed more than once if it's stored in several places.
+func (d *objectDir) forEach(fn func(id SHA1) error) error {
+	for _, pack := range d.packs {
+		for i, n := 0, pack.idx.Len(); i < n; i++ {
+			if err := fn(pack.idx.EntryAt(i).ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	dirs, err := ioutil.ReadDir(d.path)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(d.path, dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if id, ok := parseLooseObjectPath(dir.Name(), file.Name()); ok {
+				if err = fn(id); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// openPack opens packs in the directory. It can be called again to pick up
+// packs added later, already opened packs are reused and packs whose files
+// have been removed are closed. If it fails, packs are left as they were.
+func (d *objectDir) openPack() error {
+	pattern := filepath.Join(d.path, "pack", "pack-*.pack")
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	opened := make(map[string]*Pack)
+	for _, pack := range d.packs {
+		opened[pack.path] = pack
+	}
+	packs := []*Pack{}
+	var added []*Pack
+	for _, file := range files {
+		pack, ok := opened[file]
+		if ok {
+			delete(opened, file)
+		} else if pack, err = openPack(file, d.hash); err != nil {
+			// The pack is being removed by someone else.
+			if os.IsNotExist(err) {
+				continue
+			}
+			for _, pack := range added {
+				pack.Close()
+			}
+			return err
+		} else {
+			added = append(added, pack)
+		}
+		packs = append(packs, pack)
+	}
+	for _, pack := range opened {
+		pack.Close()
+	}
+	d.packs = packs
+	d.unindexed = packs
+	d.midx = nil
+	d.midxPacks = nil
+
+	if midx, err := OpenMultiPackIndex(filepath.Join(d.path, "pack", multiPackIndexName)); err == nil && midx.hash == d.hash {
+		d.useMultiPackIndex(midx)
+	}
+	return nil
+}
+
+// useMultiPackIndex starts to use midx for lookup. It's ignored if midx refers
+// a pack which doesn't exist.
+func (d *objectDir) useMultiPackIndex(midx *MultiPackIndex) bool {
+	byName := make(map[string]*Pack)
+	for _, pack := range d.packs {
+		byName[pack.indexName()] = pack
+	}
+	midxPacks := make([]*Pack, len(midx.PackNames))
+	for i, name := range midx.PackNames {
+		pack, ok := byName[name]
+		if !ok {
+			return false
+		}
+		midxPacks[i] = pack
+		delete(byName, name)
+	}
+	var unindexed []*Pack
+	for _, pack := range d.packs {
+		if _, ok := byName[pack.indexName()]; ok {
+			unindexed = append(unindexed, pack)
+		}
+	}
+	d.midx = midx
+	d.midxPacks = midxPacks
+	d.unindexed = unindexed
+	return true
+}
+
+// openObjectDirs opens the object directory at path and all of alternates
+// listed in objects/info/alternates recursively. The directory at path is
+// always the first element.
+func openObjectDirs(path string, hash *HashAlgo) ([]*objectDir, error) {
+	var dirs []*objectDir
+	seen := make(map[string]bool)
+	var walk func(path string, depth int) error
+	walk = func(path string, depth int) error {
+		path = filepath.Clean(path)
+		if seen[path] {
+			return nil
+		}
+		seen[path] = true
+
+		dir, err := newObjectDir(path, hash)
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, dir)
+		if depth >= maxAlternateDepth {
+			return nil
+		}
+
+		alternates, err := readAlternates(path)
+		if err != nil {
+			return err
+		}
+		for _, alt := range alternates {
+			if err = walk(alt, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(path, 0); err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// readAlternates returns the object directories listed in info/alternates of
+// the object directory. Relative paths are resolved against the directory.
+// Alternates which don't exist are ignored like git does.
+func readAlternates(objects string) ([]string, error) {
+	f, err := os.Open(filepath.Join(objects, "info", "alternates"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var dirs []string
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := strings.TrimSpace(scan.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if line[0] == '"' {
+			if line, err = strconv.Unquote(line); err != nil {
+				return nil, err
+			}
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(objects, line)
+		}
+		if fi, err := os.Stat(line); err != nil || !fi.IsDir() {
+			continue
+		}
+		dirs = append(dirs, line)
+	}
+	if err := scan.Err(); err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
@@ -0,0 +1,182 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAlternates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var repos []*Repository
+	for _, name := range []string{"a", "b", "c"} {
+		repo, err := Init(filepath.Join(dir, name), true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repos = append(repos, repo)
+	}
+	blob := repos[0].NewBlob(bytes.NewReader([]byte("shared\n")))
+	if err = blob.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	// c -> b (relative) -> a (absolute)
+	alternates := []struct {
+		repo int
+		data string
+	}{
+		{1, "# comment\n" + filepath.Join(dir, "a", "objects") + "\n"},
+		{2, "../../b/objects\n"},
+	}
+	for _, alt := range alternates {
+		path := filepath.Join(repos[alt.repo].root, "objects", "info", "alternates")
+		if err = ioutil.WriteFile(path, []byte(alt.data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo, err := Open(filepath.Join(dir, "c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := repo.Blob(blob.SHA1())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(b.Data) != "shared\n" {
+		t.Fatalf("Unexpected blob data: %q", b.Data)
+	}
+	if n := len(repo.store.(*fsObjectStore).dirs); n != 3 {
+		t.Fatalf("Unexpected number of object directories: %d", n)
+	}
+}
+
+func TestRescanPacks(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	defer func(d time.Duration) { packRescanInterval = d }(packRescanInterval)
+	if _, _, err := repo.Stat(SHA1FromBytes([]byte{1})); err != ErrObjectNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Someone else adds a pack after the store is opened.
+	mem := NewRepository(NewMemoryObjectStore(nil), nil)
+	ids := newTestObjects(t, mem, 10)
+	w := NewPackWriter(mem)
+	w.Add(ids...)
+	if _, err := w.Save(filepath.Join(dir, "objects", "pack")); err != nil {
+		t.Fatal(err)
+	}
+	packRescanInterval = time.Hour
+	if _, _, err := repo.Stat(ids[0]); err != ErrObjectNotFound {
+		t.Fatalf("Rescanned within the interval: %v", err)
+	}
+	packRescanInterval = 0
+	for _, id := range ids {
+		if !repo.store.Has(id) {
+			t.Fatalf("%s: Not found after rescan", id)
+		}
+	}
+
+	// Keep reading an undeltified object while the pack is removed.
+	store := repo.store.(*fsObjectStore)
+	pack := store.dirs[0].packs[0]
+	var id SHA1
+	for _, id = range ids {
+		entry, err := pack.entry(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entry.Close()
+		if entry.pack != nil {
+			break
+		}
+	}
+	o, err := repo.ObjectReader(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = removePack(pack); err != nil {
+		t.Fatal(err)
+	}
+	// Still found in the opened pack until a lookup miss.
+	if _, _, err = repo.Stat(id); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, _, err = repo.Stat(SHA1FromBytes([]byte{1})); err != ErrObjectNotFound {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if n := len(store.dirs[0].packs); n != 0 || !pack.closed || pack.refs != 1 {
+		t.Errorf("Pack not dropped: %d packs, closed %v, refs %d", n, pack.closed, pack.refs)
+	}
+	b, err := ioutil.ReadAll(o)
+	o.Close()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected, _ := mem.Blob(id); !bytes.Equal(b, expected.Data) {
+		t.Errorf("Unexpected data: %q", b)
+	}
+	if pack.refs != 0 {
+		t.Errorf("Pack still referenced: %d", pack.refs)
+	}
+	if _, _, err = repo.Stat(id); err != ErrObjectNotFound {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestRescanPacksError(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	defer func(d time.Duration) { packRescanInterval = d }(packRescanInterval)
+	if _, _, err := repo.Stat(SHA1FromBytes([]byte{1})); err != ErrObjectNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A valid pack and a broken one are added after the store is opened.
+	mem := NewRepository(NewMemoryObjectStore(nil), nil)
+	ids := newTestObjects(t, mem, 3)
+	w := NewPackWriter(mem)
+	w.Add(ids...)
+	packDir := filepath.Join(dir, "objects", "pack")
+	if _, err := w.Save(packDir); err != nil {
+		t.Fatal(err)
+	}
+	broken := filepath.Join(packDir, "pack-"+strings.Repeat("f", 40))
+	for _, ext := range []string{".pack", ".idx"} {
+		if err := ioutil.WriteFile(broken+ext, []byte("broken"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A failed rescan is retried on the next lookup miss regardless of the
+	// interval.
+	store := repo.store.(*fsObjectStore)
+	store.lastScan = time.Time{}
+	packRescanInterval = time.Hour
+	if _, _, err := repo.Stat(ids[0]); err == nil || err == ErrObjectNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !store.lastScan.IsZero() || len(store.dirs[0].packs) != 0 {
+		t.Fatalf("Packs are updated by a failed rescan: %d packs", len(store.dirs[0].packs))
+	}
+	for _, ext := range []string{".pack", ".idx"} {
+		if err := os.Remove(broken + ext); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := repo.Stat(ids[0]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(store.dirs[0].packs) != 1 {
+		t.Errorf("Unexpected packs: %d", len(store.dirs[0].packs))
+	}
+}
@@ -1,11 +1,15 @@
 package git
 
 import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"sync/atomic"
 
 	"github.com/yosisa/go-git/lru"
@@ -30,15 +34,31 @@ type PackHeader struct {
 
 type Pack struct {
 	PackHeader
-	r   packReader
-	idx *PackIndexV2
+	path string
+	hash *HashAlgo
+	r    packReader
+	idx  PackIndex
+
+	bitmap    *PackBitmap
+	bitmapErr error
+
+	// The reader is closed after Close is called and all entries reading
+	// data directly from it are released.
+	mu     sync.Mutex
+	refs   int
+	closed bool
 }
 
+// OpenPack opens a pack of a SHA-1 repository.
 func OpenPack(path string) (*Pack, error) {
+	return openPack(path, HashSHA1)
+}
+
+func openPack(path string, hash *HashAlgo) (*Pack, error) {
 	path = filepath.Clean(path)
 	ext := filepath.Ext(path)
 	base := path[:len(path)-len(ext)]
-	idx, err := OpenPackIndex(base + ".idx")
+	idx, err := openPackIndex(base+".idx", hash)
 	if err != nil {
 		return nil, err
 	}
@@ -47,11 +67,16 @@ func OpenPack(path string) (*Pack, error) {
 		return nil, err
 	}
 	pack := &Pack{
-		r:   newPackReader(f),
-		idx: idx,
+		path: base + ".pack",
+		hash: hash,
+		r:    newPackReader(f),
+		idx:  idx,
 	}
-	err = pack.verify()
-	return pack, err
+	if err = pack.verify(); err != nil {
+		pack.r.Close()
+		return nil, err
+	}
+	return pack, nil
 }
 
 func (p *Pack) verify() (err error) {
@@ -61,23 +86,68 @@ func (p *Pack) verify() (err error) {
 	if p.Magic != packMagic || p.Version != 2 {
 		return ErrUnknownFormat
 	}
-	if _, err = p.r.Seek(-20, os.SEEK_END); err != nil {
+	if _, err = p.r.Seek(-int64(p.hashAlgo().Size), os.SEEK_END); err != nil {
 		return
 	}
-	var checksum SHA1
-	if err = checksum.Fill(p.r); err != nil {
+	checksum, err := p.hashAlgo().read(p.r)
+	if err != nil {
 		return
 	}
-	if checksum != p.idx.PackFileHash {
+	if checksum != p.idx.PackChecksum() {
 		return ErrChecksum
 	}
 	return
 }
 
+// hashAlgo returns the hash algorithm of object ids in the pack.
+func (p *Pack) hashAlgo() *HashAlgo {
+	return p.hash.orSHA1()
+}
+
+// basePath returns the path of the pack without the extension.
+func (p *Pack) basePath() string {
+	return p.path[:len(p.path)-len(filepath.Ext(p.path))]
+}
+
+// indexName returns the file name of the pack index.
+func (p *Pack) indexName() string {
+	name := filepath.Base(p.path)
+	return name[:len(name)-len(filepath.Ext(name))] + ".idx"
+}
+
+// Close closes the pack. Cached entries of the pack are evicted, and the
+// underlying file is closed once entries still in use are closed.
 func (p *Pack) Close() error {
+	packEntryCache.RemoveFunc(func(key, value interface{}) bool {
+		return value.(*packEntry).pack == p
+	})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if p.refs > 0 {
+		return nil
+	}
 	return p.r.Close()
 }
 
+func (p *Pack) acquire() {
+	p.mu.Lock()
+	p.refs++
+	p.mu.Unlock()
+}
+
+func (p *Pack) release() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.refs--; p.refs == 0 && p.closed {
+		return p.r.Close()
+	}
+	return nil
+}
+
 func (p *Pack) Object(id SHA1, repo *Repository) (Object, error) {
 	entry, err := p.entry(id)
 	if err != nil {
@@ -93,15 +163,25 @@ func (p *Pack) Object(id SHA1, repo *Repository) (Object, error) {
 }
 
 func (p *Pack) entry(id SHA1) (*packEntry, error) {
+	return p.entryIn(packEntryCache, id)
+}
+
+func (p *Pack) entryAt(offset int64) (*packEntry, error) {
+	return p.entryAtIn(packEntryCache, offset)
+}
+
+// entryIn is the same as entry, but resolved entries are cached in cache.
+func (p *Pack) entryIn(cache *lru.Cache, id SHA1) (*packEntry, error) {
 	entry := p.idx.Entry(id)
 	if entry == nil {
 		return nil, ErrObjectNotFound
 	}
-	return p.entryAt(entry.Offset)
+	return p.entryAtIn(cache, entry.Offset)
 }
 
-func (p *Pack) entryAt(offset int64) (*packEntry, error) {
-	if pe, ok := packEntryCache.Get(pecKey{p.idx.PackFileHash, offset}); ok {
+// entryAtIn is the same as entryAt, but resolved entries are cached in cache.
+func (p *Pack) entryAtIn(cache *lru.Cache, offset int64) (*packEntry, error) {
+	if pe, ok := cache.Get(pecKey{p.idx.PackChecksum(), offset}); ok {
 		if entry := pe.(*packEntry); entry.markInUse() {
 			return entry, nil
 		}
@@ -124,6 +204,7 @@ func (p *Pack) entryAt(offset int64) (*packEntry, error) {
 	pe := &packEntry{
 		offset:    offset,
 		headerLen: len(header),
+		size:      size,
 		used:      1,
 	}
 
@@ -151,7 +232,7 @@ func (p *Pack) entryAt(offset int64) (*packEntry, error) {
 			return nil, err
 		}
 
-		entry, err := p.entryAt(offset - ofs)
+		entry, err := p.entryAtIn(cache, offset-ofs)
 		if err != nil {
 			return nil, err
 		}
@@ -159,10 +240,10 @@ func (p *Pack) entryAt(offset int64) (*packEntry, error) {
 		if pe.buf, err = applyDelta(entry, delta); err != nil {
 			return nil, err
 		}
-		packEntryCache.Add(pecKey{p.idx.PackFileHash, offset}, pe)
+		cache.Add(pecKey{p.idx.PackChecksum(), offset}, pe)
 		return pe, nil
 	case packEntryRefDelta:
-		id, err := readSHA1(p.r)
+		id, err := p.hashAlgo().read(p.r)
 		if err != nil {
 			return nil, err
 		}
@@ -171,7 +252,7 @@ func (p *Pack) entryAt(offset int64) (*packEntry, error) {
 			return nil, err
 		}
 
-		entry, err := p.entry(id)
+		entry, err := p.entryIn(cache, id)
 		if err != nil {
 			return nil, err
 		}
@@ -179,17 +260,106 @@ func (p *Pack) entryAt(offset int64) (*packEntry, error) {
 		if pe.buf, err = applyDelta(entry, delta); err != nil {
 			return nil, err
 		}
-		packEntryCache.Add(pecKey{p.idx.PackFileHash, offset}, pe)
+		cache.Add(pecKey{p.idx.PackChecksum(), offset}, pe)
 		return pe, nil
 	default:
 		return nil, fmt.Errorf("Unknown pack entry type: %d", typ)
 	}
 
+	p.acquire()
+	pe.pack = p
 	pe.pr = p.r
-	packEntryCache.Add(pecKey{p.idx.PackFileHash, offset}, pe)
+	cache.Add(pecKey{p.idx.PackChecksum(), offset}, pe)
 	return pe, nil
 }
 
+// stat returns the type and size of the object at offset reading only headers.
+// For a deltified object, the size is read from the delta header and the type
+// is of the base at the end of the delta chain.
+func (p *Pack) stat(offset int64) (string, int64, error) {
+	if pe, ok := packEntryCache.Get(pecKey{p.idx.PackChecksum(), offset}); ok {
+		if entry := pe.(*packEntry); entry.markInUse() {
+			defer entry.Close()
+			if entry.buf != nil {
+				return entry.typ, int64(entry.buf.Len()), nil
+			}
+			return entry.typ, entry.size, nil
+		}
+	}
+
+	size := int64(-1)
+	for depth := 0; depth <= p.idx.Len(); depth++ {
+		if _, err := p.r.Seek(offset, os.SEEK_SET); err != nil {
+			return "", 0, err
+		}
+		header, err := readPackEntryHeader(p.r)
+		if err != nil {
+			return "", 0, err
+		}
+		typ := header[0].Type()
+		if size < 0 {
+			size = header[0].Size0()
+			for i, l := 0, len(header)-1; i < l; i++ {
+				size = (header[i+1].Size() << uint(4+7*i)) | size
+			}
+		}
+
+		switch typ {
+		case packEntryCommit, packEntryTree, packEntryBlob, packEntryTag:
+			return typ.String(), size, nil
+		case packEntryOfsDelta:
+			header, err := readPackEntryHeader(p.r)
+			if err != nil {
+				return "", 0, err
+			}
+			ofs := header[0].Size()
+			for _, h := range header[1:] {
+				ofs += 1
+				ofs = (ofs << 7) + h.Size()
+			}
+			if depth == 0 {
+				if size, err = p.readDeltaSize(); err != nil {
+					return "", 0, err
+				}
+			}
+			offset -= ofs
+		case packEntryRefDelta:
+			id, err := p.hashAlgo().read(p.r)
+			if err != nil {
+				return "", 0, err
+			}
+			if depth == 0 {
+				if size, err = p.readDeltaSize(); err != nil {
+					return "", 0, err
+				}
+			}
+			entry := p.idx.Entry(id)
+			if entry == nil {
+				return "", 0, ErrObjectNotFound
+			}
+			offset = entry.Offset
+		default:
+			return "", 0, fmt.Errorf("Unknown pack entry type: %d", typ)
+		}
+	}
+	return "", 0, ErrInvalidDelta
+}
+
+// readDeltaSize reads the size of the object reconstructed by the delta.
+func (p *Pack) readDeltaSize() (int64, error) {
+	zr, err := p.r.ZlibReader()
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+	br := bufio.NewReaderSize(zr, 16)
+	if _, err = deltaHeaderSize(br); err != nil {
+		return 0, err
+	}
+	size, err := deltaHeaderSize(br)
+	return int64(size), err
+}
+
 func (p *Pack) readDelta() (*bytesBuffer, error) {
 	zr, err := p.r.ZlibReader()
 	if err != nil {
@@ -212,12 +382,28 @@ const (
 	packEntryRefDelta
 )
 
+func (t packEntryType) String() string {
+	switch t {
+	case packEntryCommit:
+		return "commit"
+	case packEntryTree:
+		return "tree"
+	case packEntryBlob:
+		return "blob"
+	case packEntryTag:
+		return "tag"
+	}
+	return ""
+}
+
 type packEntry struct {
 	typ       string
 	buf       *bytesBuffer
 	pr        packReader
+	pack      *Pack // holds a reference while pr is used
 	offset    int64
 	headerLen int
+	size      int64
 	used      int32
 }
 
@@ -245,10 +431,45 @@ func (p *packEntry) ReadAll() ([]byte, error) {
 	return p.buf.Bytes(), nil
 }
 
+// Reader returns a reader of the object. An undeltified object is inflated on
+// the fly from the pack, and a deltified one is read from the resolved data.
+func (p *packEntry) Reader() (*ObjectReader, error) {
+	if p.buf != nil {
+		return &ObjectReader{
+			Type:   p.typ,
+			Size:   int64(p.buf.Len()),
+			r:      bytes.NewReader(p.buf.Bytes()),
+			remain: int64(p.buf.Len()),
+			closer: p,
+		}, nil
+	}
+	zr, err := zlib.NewReader(p.pr.NewReader(p.offset + int64(p.headerLen)))
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	return &ObjectReader{
+		Type:   p.typ,
+		Size:   p.size,
+		r:      zr,
+		remain: p.size,
+		closer: closerFunc(func() error {
+			zr.Close()
+			return p.Close()
+		}),
+	}, nil
+}
+
 func (p *packEntry) Close() (err error) {
-	// Release bytesBuffer only if no one used and not in the lru cache.
-	if n := atomic.AddInt32(&p.used, -1); n < 0 && p.buf != nil {
-		p.buf.Close()
+	// Release bytesBuffer and the pack only if no one used and not in the
+	// lru cache.
+	if n := atomic.AddInt32(&p.used, -1); n < 0 {
+		if p.buf != nil {
+			p.buf.Close()
+		}
+		if p.pack != nil {
+			err = p.pack.release()
+		}
 	}
 	return
 }
@@ -0,0 +1,85 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestLargePackOffset reads an object placed beyond 4GiB in a sparse pack
+// file through both pack readers.
+func TestLargePackOffset(t *testing.T) {
+	f, err := ioutil.TempFile("", "go-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	data := []byte("object placed at a large offset\n")
+	offset := int64(1<<32 + 12345)
+	buf := new(bytes.Buffer)
+	buf.Write(encodePackEntryHeader(packEntryBlob, int64(len(data))))
+	writeCompressed(buf, data)
+	if _, err = f.WriteAt(buf.Bytes(), offset); err != nil {
+		t.Skipf("Sparse file not supported: %v", err)
+	}
+
+	id := HashSHA1.hashObject("blob", data)
+	readers := map[string]func(*os.File) (packReader, error){
+		"std": func(f *os.File) (packReader, error) {
+			return &stdPackReader{f: f, br: bufio.NewReader(f)}, nil
+		},
+		"mmap": func(f *os.File) (packReader, error) {
+			return newMMapPackReader(f)
+		},
+	}
+	var n byte
+	for name, newReader := range readers {
+		file, err := os.Open(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := newReader(file)
+		if err != nil {
+			file.Close()
+			t.Logf("%s: Skipped: %v", name, err)
+			continue
+		}
+		// Use a distinct checksum for each reader to avoid the entry cache.
+		n++
+		pack := &Pack{
+			r:   r,
+			idx: newPackIndexV2([]packedObject{{id, offset, 0}}, SHA1FromBytes([]byte{0xff, n})),
+		}
+		entry, err := pack.entry(id)
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %v", name, err)
+		}
+		o, err := entry.Reader()
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %v", name, err)
+		}
+		if got, err := ioutil.ReadAll(o); err != nil || o.Size != int64(len(data)) || !bytes.Equal(got, data) {
+			t.Errorf("%s: Unexpected streamed data: %q, %v", name, got, err)
+		}
+		o.Close()
+
+		entry, err = pack.entry(id)
+		if err != nil {
+			t.Errorf("%s: Unexpected error: %v", name, err)
+		} else if got, err := entry.ReadAll(); err != nil || !bytes.Equal(got, data) {
+			t.Errorf("%s: Unexpected data: %q, %v", name, got, err)
+		}
+		if entry != nil {
+			entry.Close()
+		}
+
+		if pos, err := r.Seek(-4, os.SEEK_END); err != nil || pos != offset+int64(buf.Len())-4 {
+			t.Errorf("%s: Unexpected seek: %d, %v", name, pos, err)
+		}
+		pack.Close()
+	}
+}
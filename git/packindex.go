@@ -3,7 +3,6 @@ package git
 import (
 	"bufio"
 	"bytes"
-	"crypto/sha1"
 	"encoding/binary"
 	"io"
 	"os"
@@ -12,6 +11,10 @@ import (
 
 var packIndexV2Magic = [4]byte{0xff, 't', 'O', 'c'}
 
+// packLargeOffset is the MSB of an offset in the index which indicates the
+// offset is stored in the large offset table.
+const packLargeOffset = 0x80000000
+
 type CRC32 [4]byte
 
 type PackIndexV2Header struct {
@@ -28,10 +31,14 @@ type PackIndexV2 struct {
 	LargeOffsets  []uint64
 	PackFileHash  SHA1
 	PackIndexHash SHA1
+
+	// hash is the algorithm of ids, SHA-1 if nil.
+	hash *HashAlgo
 }
 
 func (idx *PackIndexV2) Parse(r io.Reader) (err error) {
-	hasher := sha1.New()
+	h := idx.hash.orSHA1()
+	hasher := h.New()
 	r = io.TeeReader(r, hasher)
 
 	if err = binary.Read(r, binary.BigEndian, &idx.PackIndexV2Header); err != nil {
@@ -44,7 +51,7 @@ func (idx *PackIndexV2) Parse(r io.Reader) (err error) {
 	total := int(idx.Fanout[255])
 	idx.Objects = make([]SHA1, total, total)
 	for i := 0; i < total; i++ {
-		if err = idx.Objects[i].Fill(r); err != nil {
+		if idx.Objects[i], err = h.read(r); err != nil {
 			return
 		}
 	}
@@ -63,37 +70,191 @@ func (idx *PackIndexV2) Parse(r io.Reader) (err error) {
 
 	var largeOffsets int
 	for _, offset := range idx.Offsets {
-		if (offset >> 31) == 1 {
+		if offset&packLargeOffset != 0 {
 			largeOffsets++
 		}
 	}
+	for _, offset := range idx.Offsets {
+		if offset&packLargeOffset != 0 && int(offset&^packLargeOffset) >= largeOffsets {
+			return ErrUnknownFormat
+		}
+	}
 	idx.LargeOffsets = make([]uint64, largeOffsets, largeOffsets)
 	if err = binary.Read(r, binary.BigEndian, idx.LargeOffsets); err != nil {
 		return
 	}
 
-	if err = idx.PackFileHash.Fill(r); err != nil {
+	if idx.PackFileHash, err = h.read(r); err != nil {
 		return
 	}
 
-	checksum := hasher.Sum(nil)
-	if err = idx.PackIndexHash.Fill(r); err != nil {
+	checksum := h.Sum(hasher)
+	if idx.PackIndexHash, err = h.read(r); err != nil {
 		return
 	}
-	if !bytes.Equal(checksum, idx.PackIndexHash[:]) {
+	if checksum != idx.PackIndexHash {
 		return ErrChecksum
 	}
 	return
 }
 
+// Encode writes the index in the format read by Parse. PackIndexHash is
+// updated with the checksum of written data.
+func (idx *PackIndexV2) Encode(w io.Writer) (err error) {
+	h := idx.hash.orSHA1()
+	hasher := h.New()
+	mw := io.MultiWriter(w, hasher)
+
+	if err = binary.Write(mw, binary.BigEndian, &idx.PackIndexV2Header); err != nil {
+		return
+	}
+	for _, id := range idx.Objects {
+		if _, err = mw.Write(id.Bytes()); err != nil {
+			return
+		}
+	}
+	for _, crc := range idx.CRC32s {
+		if _, err = mw.Write(crc[:]); err != nil {
+			return
+		}
+	}
+	if err = binary.Write(mw, binary.BigEndian, idx.Offsets); err != nil {
+		return
+	}
+	if err = binary.Write(mw, binary.BigEndian, idx.LargeOffsets); err != nil {
+		return
+	}
+	if _, err = mw.Write(idx.PackFileHash.Bytes()); err != nil {
+		return
+	}
+
+	idx.PackIndexHash = h.Sum(hasher)
+	_, err = w.Write(idx.PackIndexHash.Bytes())
+	return
+}
+
 func (idx *PackIndexV2) Entry(id SHA1) *PackIndexEntry {
-	lower := 0
-	if id[0] != 0 {
-		lower = int(idx.Fanout[int(id[0])-1])
+	x := searchFanout(&idx.Fanout, idx.Objects, id)
+	if x < 0 {
+		return nil
 	}
-	upper := int(idx.Fanout[int(id[0])])
-	entries := idx.Objects[lower:upper]
+	return idx.EntryAt(x)
+}
 
+func (idx *PackIndexV2) EntryAt(i int) *PackIndexEntry {
+	return &PackIndexEntry{
+		ID:     idx.Objects[i],
+		Offset: idx.offset(i),
+	}
+}
+
+// offset returns the offset of i-th object. If the MSB of the offset is set,
+// the rest bits are an index of the large offset table which has 64-bit
+// offsets for packs larger than 2GiB.
+func (idx *PackIndexV2) offset(i int) int64 {
+	offset := idx.Offsets[i]
+	if offset&packLargeOffset == 0 {
+		return int64(offset)
+	}
+	return int64(idx.LargeOffsets[offset&^packLargeOffset])
+}
+
+func (idx *PackIndexV2) Len() int {
+	return len(idx.Objects)
+}
+
+func (idx *PackIndexV2) PackChecksum() SHA1 {
+	return idx.PackFileHash
+}
+
+func (idx *PackIndexV2) fanout() (*[256]uint32, []SHA1) {
+	return &idx.Fanout, idx.Objects
+}
+
+// PackIndexV1 is the legacy pack index format which has no magic number, CRC32
+// and large offsets.
+type PackIndexV1 struct {
+	Fanout        [256]uint32
+	Objects       []SHA1
+	Offsets       []uint32
+	PackFileHash  SHA1
+	PackIndexHash SHA1
+
+	// hash is the algorithm of ids, SHA-1 if nil.
+	hash *HashAlgo
+}
+
+func (idx *PackIndexV1) Parse(r io.Reader) (err error) {
+	h := idx.hash.orSHA1()
+	hasher := h.New()
+	r = io.TeeReader(r, hasher)
+
+	if err = binary.Read(r, binary.BigEndian, &idx.Fanout); err != nil {
+		return
+	}
+	for i := 1; i < len(idx.Fanout); i++ {
+		if idx.Fanout[i] < idx.Fanout[i-1] {
+			return ErrUnknownFormat
+		}
+	}
+
+	total := int(idx.Fanout[255])
+	idx.Objects = make([]SHA1, total, total)
+	idx.Offsets = make([]uint32, total, total)
+	for i := 0; i < total; i++ {
+		if err = binary.Read(r, binary.BigEndian, &idx.Offsets[i]); err != nil {
+			return
+		}
+		if idx.Objects[i], err = h.read(r); err != nil {
+			return
+		}
+	}
+
+	if idx.PackFileHash, err = h.read(r); err != nil {
+		return
+	}
+
+	checksum := h.Sum(hasher)
+	if idx.PackIndexHash, err = h.read(r); err != nil {
+		return
+	}
+	if checksum != idx.PackIndexHash {
+		return ErrChecksum
+	}
+	return
+}
+
+func (idx *PackIndexV1) Entry(id SHA1) *PackIndexEntry {
+	x := searchFanout(&idx.Fanout, idx.Objects, id)
+	if x < 0 {
+		return nil
+	}
+	return idx.EntryAt(x)
+}
+
+func (idx *PackIndexV1) EntryAt(i int) *PackIndexEntry {
+	return &PackIndexEntry{
+		ID:     idx.Objects[i],
+		Offset: int64(idx.Offsets[i]),
+	}
+}
+
+func (idx *PackIndexV1) Len() int {
+	return len(idx.Objects)
+}
+
+func (idx *PackIndexV1) PackChecksum() SHA1 {
+	return idx.PackFileHash
+}
+
+func (idx *PackIndexV1) fanout() (*[256]uint32, []SHA1) {
+	return &idx.Fanout, idx.Objects
+}
+
+// searchFanout finds id from objects sorted by id with the help of fanout
+// table. It returns -1 if not found.
+func searchFanout(fanout *[256]uint32, objects []SHA1, id SHA1) int {
+	lower, entries := fanoutRange(fanout, objects, id.hash[0])
 	var found bool
 	x := sort.Search(len(entries), func(i int) bool {
 		n := entries[i].Compare(id)
@@ -103,13 +264,36 @@ func (idx *PackIndexV2) Entry(id SHA1) *PackIndexEntry {
 		return n >= 0
 	})
 	if !found {
-		return nil
+		return -1
 	}
-	x += lower
-	return &PackIndexEntry{
-		ID:     id,
-		Offset: int64(idx.Offsets[x]),
+	return x + lower
+}
+
+// fanoutRange returns objects whose ids start with the byte first, and the
+// position of the first one. Nothing is returned if fanout is broken.
+func fanoutRange(fanout *[256]uint32, objects []SHA1, first byte) (int, []SHA1) {
+	lower := 0
+	if first != 0 {
+		lower = int(fanout[first-1])
+	}
+	upper := int(fanout[first])
+	if lower > upper || upper > len(objects) {
+		return 0, nil
 	}
+	return lower, objects[lower:upper]
+}
+
+// PackIndex is an index of a pack file which maps object ids to offsets in the
+// pack. Entries are sorted by object id.
+type PackIndex interface {
+	// Entry returns the entry of id, or nil if the pack doesn't contain it.
+	Entry(id SHA1) *PackIndexEntry
+	// EntryAt returns the i-th entry.
+	EntryAt(i int) *PackIndexEntry
+	// Len returns the number of objects in the pack.
+	Len() int
+	// PackChecksum returns the checksum of the pack file.
+	PackChecksum() SHA1
 }
 
 type PackIndexEntry struct {
@@ -117,7 +301,15 @@ type PackIndexEntry struct {
 	Offset int64
 }
 
-func OpenPackIndex(path string) (*PackIndexV2, error) {
+// OpenPackIndex opens a pack index file of a SHA-1 repository. Both version 1
+// and 2 are supported.
+func OpenPackIndex(path string) (PackIndex, error) {
+	return openPackIndex(path, HashSHA1)
+}
+
+// openPackIndex opens a pack index file whose ids are computed by hash. The
+// index itself has no information about the algorithm.
+func openPackIndex(path string, hash *HashAlgo) (PackIndex, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -130,9 +322,15 @@ func OpenPackIndex(path string) (*PackIndexV2, error) {
 		return nil, err
 	}
 	if !bytes.Equal(magic, packIndexV2Magic[:]) {
-		return nil, ErrUnknownFormat
+		idx := &PackIndexV1{hash: hash}
+		if err = idx.Parse(buf); err != nil {
+			return nil, err
+		}
+		return idx, nil
+	}
+	idx := &PackIndexV2{hash: hash}
+	if err = idx.Parse(buf); err != nil {
+		return nil, err
 	}
-	idx := new(PackIndexV2)
-	err = idx.Parse(buf)
-	return idx, err
+	return idx, nil
 }
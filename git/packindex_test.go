@@ -1,6 +1,11 @@
 package git
 
-import "testing"
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"testing"
+)
 
 func TestEntry(t *testing.T) {
 	idx := new(PackIndexV2)
@@ -31,3 +36,83 @@ func TestEntry(t *testing.T) {
 		t.Errorf("unexpected match: %s %d", entry.ID, entry.Offset)
 	}
 }
+
+func TestPackIndexV1(t *testing.T) {
+	ids := []SHA1{
+		SHA1FromHexString("0010000000000000000000000000000000000000"),
+		SHA1FromHexString("0100000000000000000000000000000000000000"),
+		SHA1FromHexString("ff10000000000000000000000000000000000000"),
+	}
+	var fanout [256]uint32
+	for _, id := range ids {
+		for i := int(id.Bytes()[0]); i < len(fanout); i++ {
+			fanout[i]++
+		}
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, fanout)
+	for i, id := range ids {
+		binary.Write(buf, binary.BigEndian, uint32(100*i+12))
+		buf.Write(id.Bytes())
+	}
+	buf.Write(make([]byte, 20))
+	checksum := sha1.Sum(buf.Bytes())
+	buf.Write(checksum[:])
+
+	idx := new(PackIndexV1)
+	if err := idx.Parse(buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n := idx.Len(); n != len(ids) {
+		t.Fatalf("Unexpected number of objects: %d", n)
+	}
+	for i, id := range ids {
+		entry := idx.Entry(id)
+		if entry == nil {
+			t.Errorf("%s: not found", id)
+		} else if entry.Offset != int64(100*i+12) {
+			t.Errorf("%s: unexpected offset: %d", id, entry.Offset)
+		}
+	}
+	if entry := idx.Entry(SHA1FromHexString("ff00000000000000000000000000000000000000")); entry != nil {
+		t.Errorf("unexpected match: %s %d", entry.ID, entry.Offset)
+	}
+}
+
+func TestEntryLargeOffset(t *testing.T) {
+	objects := []packedObject{
+		{SHA1FromHexString("0010000000000000000000000000000000000000"), 12, 1},
+		{SHA1FromHexString("0100000000000000000000000000000000000000"), 1<<31 - 1, 2},
+		{SHA1FromHexString("8000000000000000000000000000000000000000"), 1 << 31, 3},
+		{SHA1FromHexString("ff00000000000000000000000000000000000000"), 5<<32 + 7, 4},
+	}
+	idx := newPackIndexV2(objects, SHA1{})
+	if n := len(idx.LargeOffsets); n != 2 {
+		t.Fatalf("Unexpected number of large offsets: %d", n)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := idx.Encode(buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	parsed := new(PackIndexV2)
+	if err := parsed.Parse(buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, obj := range objects {
+		entry := parsed.Entry(obj.id)
+		if entry == nil {
+			t.Errorf("%s: not found", obj.id)
+		} else if entry.Offset != obj.offset {
+			t.Errorf("%s: unexpected offset: %d != %d", obj.id, entry.Offset, obj.offset)
+		}
+	}
+
+	// An index of the large offset table out of range is rejected.
+	idx.Offsets[3] = packLargeOffset | 2
+	buf.Reset()
+	idx.Encode(buf)
+	if err := new(PackIndexV2).Parse(buf); err != ErrUnknownFormat {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
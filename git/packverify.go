@@ -0,0 +1,256 @@
+package git
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/yosisa/go-git/lru"
+)
+
+var ErrCRC32Mismatch = errors.New("CRC32 mismatch")
+
+// PackVerifyEntry describes an object in a pack like a line of
+// git verify-pack -v.
+type PackVerifyEntry struct {
+	ID SHA1
+	// Type is the type of the object after delta resolution.
+	Type string
+	// Size is the size in the entry header, which is the size of the delta
+	// data for a deltified object.
+	Size int64
+	// PackedSize is the number of bytes the entry takes in the pack.
+	PackedSize int64
+	Offset     int64
+	// Depth is the length of the delta chain, 0 if not deltified.
+	Depth int
+	// Base is the delta base of a deltified object.
+	Base SHA1
+}
+
+type PackVerifyResult struct {
+	// Entries are ordered by the offset.
+	Entries []*PackVerifyEntry
+	// Chains maps a delta chain length to the number of objects.
+	Chains map[int]int
+	// Broken are errors of broken objects ordered by the offset.
+	Broken []*FsckError
+}
+
+// Verify checks the integrity of the pack. In addition to the pack checksum,
+// each entry is checked with CRC32 recorded in the index if it's version 2,
+// and each object is hashed again after delta resolution. Objects are read
+// from the pack file bypassing the cache of resolved objects.
+//
+// All broken objects are reported in Broken of the result, and the first one
+// is also returned as the error. The pack checksum is checked only if no object
+// is broken.
+func (p *Pack) Verify() (*PackVerifyResult, error) {
+	n := p.idx.Len()
+	if int(p.Total) != n {
+		return nil, ErrUnknownFormat
+	}
+	entries := make([]*PackVerifyEntry, n)
+	positions := make(map[int64]int, n)
+	for i := range entries {
+		entry := p.idx.EntryAt(i)
+		entries[i] = &PackVerifyEntry{ID: entry.ID, Offset: entry.Offset}
+		positions[entry.Offset] = i
+	}
+	sorted := make([]*PackVerifyEntry, n)
+	copy(sorted, entries)
+	sort.Sort(byVerifyOffset(sorted))
+
+	f, err := os.Open(p.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	h := p.hashAlgo()
+	end := fi.Size() - int64(h.Size)
+	hash := h.New()
+	r := bufio.NewReader(io.TeeReader(io.LimitReader(f, end), hash))
+	header := make([]byte, binary.Size(p.PackHeader))
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	cache := lru.NewWithEvict(1<<24, func(key interface{}, value interface{}) {
+		value.(*packEntry).Close()
+	})
+	defer cache.RemoveFunc(func(key, value interface{}) bool { return true })
+
+	result := &PackVerifyResult{Entries: sorted, Chains: make(map[int]int)}
+	broken := func(entry *PackVerifyEntry, err error) {
+		result.Broken = append(result.Broken, &FsckError{entry.ID, entry.Type, err})
+	}
+	v2, _ := p.idx.(*PackIndexV2)
+	bases := make(map[int64]int64, n)
+	var raw []byte
+	for i, entry := range sorted {
+		next := end
+		if i+1 < n {
+			next = sorted[i+1].Offset
+		}
+		entry.PackedSize = next - entry.Offset
+		if entry.PackedSize <= 0 {
+			return nil, ErrUnknownFormat
+		}
+		if int64(cap(raw)) < entry.PackedSize {
+			raw = make([]byte, entry.PackedSize)
+		}
+		raw = raw[:entry.PackedSize]
+		if _, err = io.ReadFull(r, raw); err != nil {
+			return nil, err
+		}
+		if v2 != nil {
+			crc := v2.CRC32s[positions[entry.Offset]]
+			if crc32.ChecksumIEEE(raw) != binary.BigEndian.Uint32(crc[:]) {
+				broken(entry, ErrCRC32Mismatch)
+				continue
+			}
+		}
+
+		typ, size, base, err := p.parseVerifyEntry(entry, raw)
+		if err != nil {
+			broken(entry, err)
+			continue
+		}
+		entry.Size = size
+		if typ == packEntryOfsDelta || typ == packEntryRefDelta {
+			pos, ok := positions[base]
+			if !ok {
+				broken(entry, ErrUnknownFormat)
+				continue
+			}
+			bases[entry.Offset] = base
+			entry.Base = entries[pos].ID
+		}
+
+		pe, err := p.entryAtIn(cache, entry.Offset)
+		if err != nil {
+			broken(entry, err)
+			continue
+		}
+		entry.Type = pe.Type()
+		data, err := pe.ReadAll()
+		if err == nil && h.hashObject(entry.Type, data) != entry.ID {
+			err = ErrHashMismatch
+		}
+		pe.Close()
+		if err != nil {
+			broken(entry, err)
+		}
+	}
+
+	depths := make(map[int64]int, n)
+	var depth func(offset int64, limit int) (int, error)
+	depth = func(offset int64, limit int) (int, error) {
+		base, ok := bases[offset]
+		if !ok {
+			return 0, nil
+		}
+		if d, ok := depths[offset]; ok {
+			return d, nil
+		}
+		if limit == 0 {
+			return 0, ErrUnknownFormat
+		}
+		d, err := depth(base, limit-1)
+		depths[offset] = d + 1
+		return d + 1, err
+	}
+	for _, entry := range sorted {
+		if entry.Depth, err = depth(entry.Offset, n); err != nil {
+			return nil, err
+		}
+		if entry.Depth > 0 {
+			result.Chains[entry.Depth]++
+		}
+	}
+	if len(result.Broken) > 0 {
+		return result, result.Broken[0]
+	}
+
+	b := make([]byte, h.Size)
+	if _, err = f.ReadAt(b, end); err != nil {
+		return nil, err
+	}
+	if checksum := h.FromBytes(b); h.Sum(hash) != checksum || checksum != p.idx.PackChecksum() {
+		return nil, ErrChecksum
+	}
+	return result, nil
+}
+
+// parseVerifyEntry parses the header of the raw entry, and returns the offset
+// of the delta base if it's deltified.
+func (p *Pack) parseVerifyEntry(entry *PackVerifyEntry, raw []byte) (typ packEntryType, size, base int64, err error) {
+	i := 0
+	next := func() (packEntryHeader, error) {
+		if i >= len(raw) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		i++
+		return packEntryHeader(raw[i-1]), nil
+	}
+	h, err := next()
+	if err != nil {
+		return
+	}
+	typ, size = h.Type(), h.Size0()
+	for shift := uint(4); h.MSB(); shift += 7 {
+		if h, err = next(); err != nil {
+			return
+		}
+		size |= h.Size() << shift
+	}
+
+	switch typ {
+	case packEntryCommit, packEntryTree, packEntryBlob, packEntryTag:
+	case packEntryOfsDelta:
+		if h, err = next(); err != nil {
+			return
+		}
+		ofs := h.Size()
+		for h.MSB() {
+			if h, err = next(); err != nil {
+				return
+			}
+			ofs = (ofs+1)<<7 + h.Size()
+		}
+		if ofs <= 0 || ofs > entry.Offset {
+			err = ErrUnknownFormat
+			return
+		}
+		base = entry.Offset - ofs
+	case packEntryRefDelta:
+		h := p.hashAlgo()
+		if len(raw) < i+h.Size {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+		e := p.idx.Entry(h.FromBytes(raw[i : i+h.Size]))
+		if e == nil {
+			err = ErrObjectNotFound
+			return
+		}
+		base = e.Offset
+	default:
+		err = ErrUnknownFormat
+	}
+	return
+}
+
+type byVerifyOffset []*PackVerifyEntry
+
+func (z byVerifyOffset) Len() int           { return len(z) }
+func (z byVerifyOffset) Swap(i, j int)      { z[i], z[j] = z[j], z[i] }
+func (z byVerifyOffset) Less(i, j int) bool { return z[i].Offset < z[j].Offset }
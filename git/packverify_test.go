@@ -0,0 +1,109 @@
+package git
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPackVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := NewRepository(NewMemoryObjectStore(nil), nil)
+	ids := newTestObjects(t, repo, 20)
+	w := NewPackWriter(repo)
+	w.Add(ids...)
+	path, err := w.Save(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pack, err := OpenPack(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := pack.Verify()
+	pack.Close()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Entries) != len(ids) || len(result.Chains) == 0 {
+		t.Fatalf("Unexpected result: %d entries, chains %v", len(result.Entries), result.Chains)
+	}
+	var deltified *PackVerifyEntry
+	for i, entry := range result.Entries {
+		if i > 0 && entry.Offset <= result.Entries[i-1].Offset {
+			t.Errorf("Entries are not sorted by offset")
+		}
+		if entry.Depth > 0 {
+			deltified = entry
+			if entry.Base.Empty() || entry.Type != "blob" {
+				t.Errorf("Unexpected deltified entry: %+v", entry)
+			}
+		}
+	}
+	if deltified == nil {
+		t.Fatal("No deltified entry")
+	}
+
+	// Corrupt the deltified entry after it's cached, keeping its CRC32
+	// consistent. The pack checksum is not updated, so it can be opened.
+	if pack, err = OpenPack(path); err != nil {
+		t.Fatal(err)
+	}
+	defer pack.Close()
+	pe, err := pack.entryAt(deltified.Offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pe.ReadAll(); err != nil {
+		t.Fatal(err)
+	}
+	pe.Close()
+	if err = os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	corrupt := func(entry *PackVerifyEntry) []byte {
+		f, err := os.OpenFile(path, os.O_RDWR, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		raw := make([]byte, entry.PackedSize)
+		if _, err = f.ReadAt(raw, entry.Offset); err != nil {
+			t.Fatal(err)
+		}
+		raw[len(raw)-1] ^= 0xff
+		if _, err = f.WriteAt(raw[len(raw)-1:], entry.Offset+entry.PackedSize-1); err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	raw := corrupt(deltified)
+	idx := pack.idx.(*PackIndexV2)
+	for i := 0; i < idx.Len(); i++ {
+		if idx.EntryAt(i).ID == deltified.ID {
+			binary.BigEndian.PutUint32(idx.CRC32s[i][:], crc32.ChecksumIEEE(raw))
+		}
+	}
+	_, err = pack.Verify()
+	if e, ok := err.(*FsckError); !ok || e.ID != deltified.ID || e.Err == ErrCRC32Mismatch {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// All broken entries are reported.
+	first := result.Entries[0]
+	corrupt(first)
+	result, err = pack.Verify()
+	if e, ok := err.(*FsckError); !ok || e.ID != first.ID || e.Err != ErrCRC32Mismatch {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if result == nil || len(result.Broken) != 2 || result.Broken[1].ID != deltified.ID {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
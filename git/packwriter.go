@@ -0,0 +1,453 @@
+package git
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var ErrEmptyObjectID = errors.New("Object has no id, it must be written first")
+
+const (
+	defaultPackWindow = 10
+	defaultPackDepth  = 50
+
+	// Objects larger than this are never deltified.
+	maxDeltaObjectSize = 1 << 29
+)
+
+// PackWriter builds a version 2 pack file and its index from objects stored in
+// a repository. Objects are stored as OFS_DELTA against a similar object if
+// it saves space.
+type PackWriter struct {
+	// Window is the number of preceding objects to be tried as a delta base.
+	// Zero disables delta compression.
+	Window int
+	// Depth is the maximum length of delta chains.
+	Depth int
+
+	repo    *Repository
+	objects []*packObject
+	seen    map[SHA1]bool
+}
+
+func NewPackWriter(repo *Repository) *PackWriter {
+	return &PackWriter{
+		Window: defaultPackWindow,
+		Depth:  defaultPackDepth,
+		repo:   repo,
+		seen:   make(map[SHA1]bool),
+	}
+}
+
+// Add adds objects to be packed. Duplicated ids are ignored.
+func (w *PackWriter) Add(ids ...SHA1) {
+	for _, id := range ids {
+		w.AddNamed(id, "")
+	}
+}
+
+// AddNamed adds an object with its path name. Objects with similar names are
+// likely to be good delta bases of each other, so giving names improves delta
+// compression.
+func (w *PackWriter) AddNamed(id SHA1, name string) {
+	if w.seen[id] {
+		return
+	}
+	w.seen[id] = true
+	w.objects = append(w.objects, &packObject{
+		id:       id,
+		nameHash: packNameHash(name),
+	})
+}
+
+// AddObject adds objects to be packed. The objects must be already written to
+// the repository.
+func (w *PackWriter) AddObject(objs ...Object) error {
+	for _, obj := range objs {
+		if obj.SHA1().Empty() {
+			return ErrEmptyObjectID
+		}
+		w.Add(obj.SHA1())
+	}
+	return nil
+}
+
+// Len returns the number of objects to be packed.
+func (w *PackWriter) Len() int {
+	return len(w.objects)
+}
+
+// Encode writes the pack stream to out and returns the index of it.
+func (w *PackWriter) Encode(out io.Writer) (*PackIndexV2, error) {
+	if w.Window > 0 {
+		for _, obj := range w.objects {
+			if err := w.readHeader(obj); err != nil {
+				return nil, err
+			}
+		}
+		sort.Stable(byDeltaOrder(w.objects))
+	}
+
+	enc := newPackEncoder(out, w.repo.hash)
+	header := PackHeader{
+		Magic:   packMagic,
+		Version: 2,
+		Total:   uint32(len(w.objects)),
+	}
+	if err := binary.Write(enc, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+
+	objects := make([]packedObject, 0, len(w.objects))
+	window := make([]*deltaCandidate, 0, w.Window)
+	for _, obj := range w.objects {
+		data, err := w.readData(obj)
+		if err != nil {
+			return nil, err
+		}
+		base, delta := w.findDelta(obj, data, window)
+		if err = w.writeEntry(enc, obj, data, base, delta); err != nil {
+			return nil, err
+		}
+		objects = append(objects, packedObject{obj.id, obj.offset, obj.crc})
+
+		if w.Window > 0 {
+			if len(window) == w.Window {
+				copy(window, window[1:])
+				window = window[:len(window)-1]
+			}
+			window = append(window, &deltaCandidate{obj, data})
+		}
+	}
+
+	checksum := w.repo.hash.Sum(enc.hash)
+	if _, err := out.Write(checksum.Bytes()); err != nil {
+		return nil, err
+	}
+	return newPackIndexV2(objects, checksum), nil
+}
+
+func (w *PackWriter) readHeader(obj *packObject) (err error) {
+	typ, size, err := w.repo.Stat(obj.id)
+	if err != nil {
+		return
+	}
+	if obj.typ, err = packEntryTypeOf(typ); err != nil {
+		return
+	}
+	obj.size = size
+	return
+}
+
+func (w *PackWriter) readData(obj *packObject) ([]byte, error) {
+	entry, err := w.repo.entry(obj.id)
+	if err != nil {
+		return nil, err
+	}
+	defer entry.Close()
+	data, err := entry.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if obj.typ, err = packEntryTypeOf(entry.Type()); err != nil {
+		return nil, err
+	}
+	obj.size = int64(len(data))
+	return cloneBytes(data), nil
+}
+
+// findDelta searches the best delta base from the window. It returns nil if no
+// delta is smaller enough than the object itself.
+func (w *PackWriter) findDelta(obj *packObject, data []byte, window []*deltaCandidate) (*packObject, []byte) {
+	if obj.size > maxDeltaObjectSize {
+		return nil, nil
+	}
+	var (
+		base  *packObject
+		delta []byte
+	)
+	maxSize := len(data)/2 - w.repo.hash.Size
+	for i := len(window) - 1; i >= 0; i-- {
+		c := window[i]
+		if c.obj.typ != obj.typ || c.obj.depth >= w.Depth || c.obj.size > maxDeltaObjectSize {
+			continue
+		}
+		// The size difference alone exceeds the limit.
+		if diff := obj.size - c.obj.size; diff > int64(maxSize) {
+			continue
+		}
+		d := createDelta(c.data, data)
+		if len(d) < maxSize {
+			base, delta, maxSize = c.obj, d, len(d)
+		}
+	}
+	return base, delta
+}
+
+func (w *PackWriter) writeEntry(enc *packEncoder, obj *packObject, data []byte, base *packObject, delta []byte) (err error) {
+	obj.offset = enc.n
+	enc.crc.Reset()
+	if base == nil {
+		if _, err = enc.Write(encodePackEntryHeader(obj.typ, obj.size)); err != nil {
+			return
+		}
+		if err = writeCompressed(enc, data); err != nil {
+			return
+		}
+	} else {
+		obj.depth = base.depth + 1
+		if _, err = enc.Write(encodePackEntryHeader(packEntryOfsDelta, int64(len(delta)))); err != nil {
+			return
+		}
+		if _, err = enc.Write(encodeDeltaOffset(obj.offset - base.offset)); err != nil {
+			return
+		}
+		if err = writeCompressed(enc, delta); err != nil {
+			return
+		}
+	}
+	obj.crc = enc.crc.Sum32()
+	return
+}
+
+// Save writes the pack and its index into dir, typically objects/pack of a
+// repository. The files are named after the pack checksum like git does.
+// It returns the path of the pack file.
+func (w *PackWriter) Save(dir string) (path string, err error) {
+	var tmpPack, tmpIdx string
+	defer func() {
+		if err != nil {
+			for _, name := range []string{tmpPack, tmpIdx} {
+				if name != "" {
+					os.Remove(name)
+				}
+			}
+		}
+	}()
+
+	var idx *PackIndexV2
+	tmpPack, err = writeTempFile(dir, "tmp_pack_", func(f io.Writer) (err error) {
+		idx, err = w.Encode(f)
+		return
+	})
+	if err != nil {
+		return
+	}
+	if tmpIdx, err = writeTempFile(dir, "tmp_idx_", idx.Encode); err != nil {
+		return
+	}
+	return installPack(dir, tmpPack, tmpIdx, idx.PackFileHash)
+}
+
+// installPack renames temporary pack and index files to the final names. The
+// pack is renamed first so that the index never points a missing pack.
+func installPack(dir, tmpPack, tmpIdx string, checksum SHA1) (string, error) {
+	base := filepath.Join(dir, "pack-"+checksum.String())
+	if err := os.Rename(tmpPack, base+".pack"); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpIdx, base+".idx"); err != nil {
+		return "", err
+	}
+	return base + ".pack", nil
+}
+
+// writeTempFile creates a read-only temporary file in dir and fills it by fn.
+func writeTempFile(dir, prefix string, fn func(io.Writer) error) (path string, err error) {
+	f, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return
+	}
+	path = f.Name()
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Chmod(path, 0444)
+		}
+	}()
+
+	bw := bufio.NewWriter(f)
+	if err = fn(bw); err != nil {
+		return
+	}
+	err = bw.Flush()
+	return
+}
+
+func writeCompressed(w io.Writer, data []byte) error {
+	zw := zlib.NewWriter(w)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// packEncoder is a writer which keeps track of the offset, the checksum of the
+// whole pack and CRC32 of the current entry.
+type packEncoder struct {
+	w    io.Writer
+	n    int64
+	hash hash.Hash
+	crc  hash.Hash32
+}
+
+func newPackEncoder(w io.Writer, hash *HashAlgo) *packEncoder {
+	return &packEncoder{
+		w:    w,
+		hash: hash.New(),
+		crc:  crc32.NewIEEE(),
+	}
+}
+
+func (e *packEncoder) Write(p []byte) (int, error) {
+	n, err := e.w.Write(p)
+	e.hash.Write(p[:n])
+	e.crc.Write(p[:n])
+	e.n += int64(n)
+	return n, err
+}
+
+// packObject is an object to be written by PackWriter.
+type packObject struct {
+	id       SHA1
+	typ      packEntryType
+	size     int64
+	nameHash uint32
+	depth    int
+	offset   int64
+	crc      uint32
+}
+
+type deltaCandidate struct {
+	obj  *packObject
+	data []byte
+}
+
+// byDeltaOrder sorts objects so that similar objects are placed closely, by
+// type, name hash and size in descending order. Larger objects come first
+// because a delta which removes data is smaller than one which adds data.
+type byDeltaOrder []*packObject
+
+func (z byDeltaOrder) Len() int      { return len(z) }
+func (z byDeltaOrder) Swap(i, j int) { z[i], z[j] = z[j], z[i] }
+func (z byDeltaOrder) Less(i, j int) bool {
+	if z[i].typ != z[j].typ {
+		return z[i].typ < z[j].typ
+	}
+	if z[i].nameHash != z[j].nameHash {
+		return z[i].nameHash < z[j].nameHash
+	}
+	return z[i].size > z[j].size
+}
+
+// packNameHash is the same hash function as git uses to group objects by path
+// name. It puts importance on the last characters, so that files with the
+// same extension are grouped together.
+func packNameHash(name string) uint32 {
+	var hash uint32
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v' {
+			continue
+		}
+		hash = (hash >> 2) + (uint32(c) << 24)
+	}
+	return hash
+}
+
+// encodeDeltaOffset encodes the negative offset to the base of OFS_DELTA.
+func encodeDeltaOffset(ofs int64) []byte {
+	var buf [10]byte
+	pos := len(buf) - 1
+	buf[pos] = byte(ofs & 0x7f)
+	for ofs >>= 7; ofs > 0; ofs >>= 7 {
+		ofs--
+		pos--
+		buf[pos] = 0x80 | byte(ofs&0x7f)
+	}
+	return buf[pos:]
+}
+
+// packedObject is an object location in a pack used to build the index.
+type packedObject struct {
+	id     SHA1
+	offset int64
+	crc    uint32
+}
+
+type byPackedID []packedObject
+
+func (z byPackedID) Len() int           { return len(z) }
+func (z byPackedID) Swap(i, j int)      { z[i], z[j] = z[j], z[i] }
+func (z byPackedID) Less(i, j int) bool { return z[i].id.Compare(z[j].id) < 0 }
+
+func newPackIndexV2(objects []packedObject, checksum SHA1) *PackIndexV2 {
+	sorted := make([]packedObject, len(objects))
+	copy(sorted, objects)
+	sort.Sort(byPackedID(sorted))
+
+	idx := &PackIndexV2{
+		PackIndexV2Header: PackIndexV2Header{
+			Magic:   packIndexV2Magic,
+			Version: 2,
+		},
+		Objects:      make([]SHA1, len(sorted)),
+		CRC32s:       make([]CRC32, len(sorted)),
+		Offsets:      make([]uint32, len(sorted)),
+		PackFileHash: checksum,
+		hash:         checksum.Algo(),
+	}
+	for i, obj := range sorted {
+		idx.Fanout[obj.id.hash[0]]++
+		idx.Objects[i] = obj.id
+		binary.BigEndian.PutUint32(idx.CRC32s[i][:], obj.crc)
+		if obj.offset < packLargeOffset {
+			idx.Offsets[i] = uint32(obj.offset)
+		} else {
+			idx.Offsets[i] = packLargeOffset | uint32(len(idx.LargeOffsets))
+			idx.LargeOffsets = append(idx.LargeOffsets, uint64(obj.offset))
+		}
+	}
+	for i := 1; i < len(idx.Fanout); i++ {
+		idx.Fanout[i] += idx.Fanout[i-1]
+	}
+	return idx
+}
+
+func packEntryTypeOf(typ string) (packEntryType, error) {
+	switch typ {
+	case "commit":
+		return packEntryCommit, nil
+	case "tree":
+		return packEntryTree, nil
+	case "blob":
+		return packEntryBlob, nil
+	case "tag":
+		return packEntryTag, nil
+	}
+	return packEntryNone, ErrUnknownFormat
+}
+
+// encodePackEntryHeader encodes the type and the size of a pack entry in the
+// variable length format read by readPackEntryHeader.
+func encodePackEntryHeader(typ packEntryType, size int64) []byte {
+	b := []byte{byte(typ)<<4 | byte(size&0x0f)}
+	size >>= 4
+	for size > 0 {
+		b[len(b)-1] |= 0x80
+		b = append(b, byte(size&0x7f))
+		size >>= 7
+	}
+	return b
+}
@@ -0,0 +1,171 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// newTestRepo initializes a repository in a temporary directory, which is
+// removed when the test finishes.
+func newTestRepo(t *testing.T, bare bool) (*Repository, string) {
+	dir, err := ioutil.TempDir("", "go-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	repo, err := Init(dir, bare, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo, dir
+}
+
+func newTestObjects(t *testing.T, repo *Repository, n int) []SHA1 {
+	var ids []SHA1
+	tree := repo.NewTree()
+	for i := 0; i < n; i++ {
+		data := bytes.Repeat([]byte(fmt.Sprintf("line %d of a test blob\n", i)), i+1)
+		blob := repo.NewBlob(bytes.NewReader(data))
+		if err := blob.Write(); err != nil {
+			t.Fatal(err)
+		}
+		tree.Add(fmt.Sprintf("dir/file%d", i), blob, ModeFile)
+		ids = append(ids, blob.SHA1())
+	}
+	if err := tree.Write(); err != nil {
+		t.Fatal(err)
+	}
+	user := NewUser("go-git", "go-git@example.com")
+	commit := repo.NewCommit(tree, nil, user, user, "test\n")
+	if err := commit.Write(); err != nil {
+		t.Fatal(err)
+	}
+	return append(ids, tree.SHA1(), commit.SHA1())
+}
+
+func TestPackWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := NewRepository(NewMemoryObjectStore(nil), nil)
+	ids := newTestObjects(t, repo, 20)
+	for _, tc := range []struct {
+		window int
+		depth  int
+	}{
+		{0, defaultPackDepth},
+		{defaultPackWindow, defaultPackDepth},
+		{defaultPackWindow, 2},
+	} {
+		w := NewPackWriter(repo)
+		w.Window = tc.window
+		w.Depth = tc.depth
+		w.Add(ids...)
+		w.Add(ids[0])
+		if n := w.Len(); n != len(ids) {
+			t.Fatalf("Unexpected number of objects: %d", n)
+		}
+		path, err := w.Save(dir)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		testPackObjects(t, repo, path, ids)
+		testPackDeltas(t, path, tc.window > 0, tc.depth)
+	}
+}
+
+// testPackDeltas checks that deltified entries are written as OFS_DELTA and
+// their chains aren't longer than depth.
+func testPackDeltas(t *testing.T, path string, deltified bool, depth int) {
+	pack, err := OpenPack(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer pack.Close()
+	result, err := pack.Verify()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var deltas int
+	for _, entry := range result.Entries {
+		header, err := readPackEntryHeader(bytes.NewReader(data[entry.Offset:]))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		typ := header[0].Type()
+		switch {
+		case entry.Depth == 0 && (typ == packEntryOfsDelta || typ == packEntryRefDelta):
+			t.Errorf("%s: Unexpected delta without a base", entry.ID)
+		case entry.Depth > 0 && typ != packEntryOfsDelta:
+			t.Errorf("%s: Unexpected entry type for a delta: %d", entry.ID, typ)
+		case entry.Depth > depth:
+			t.Errorf("%s: Delta chain is too long: %d", entry.ID, entry.Depth)
+		}
+		if entry.Depth > 0 {
+			deltas++
+		}
+	}
+	if deltified && deltas == 0 {
+		t.Errorf("No delta is written")
+	} else if !deltified && deltas != 0 {
+		t.Errorf("Unexpected number of deltas: %d", deltas)
+	}
+}
+
+func testPackObjects(t *testing.T, repo *Repository, path string, ids []SHA1) {
+	pack, err := OpenPack(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer pack.Close()
+	if n := int(pack.Total); n != len(ids) {
+		t.Fatalf("Unexpected number of objects in pack: %d", n)
+	}
+	for _, id := range ids {
+		expected, err := repo.entry(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := expected.ReadAll()
+		entry, err := pack.entry(id)
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %v", id, err)
+		}
+		got, err := entry.ReadAll()
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %v", id, err)
+		}
+		if entry.Type() != expected.Type() || !bytes.Equal(got, want) {
+			t.Errorf("%s: Object mismatch", id)
+		}
+		entry.Close()
+	}
+}
+
+func TestEncodePackEntryHeader(t *testing.T) {
+	for _, size := range []int64{0, 15, 16, 1000, 1 << 20, 1<<35 + 3} {
+		b := encodePackEntryHeader(packEntryBlob, size)
+		header, err := readPackEntryHeader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		got := header[0].Size0()
+		for i, h := range header[1:] {
+			got |= h.Size() << uint(4+7*i)
+		}
+		if header[0].Type() != packEntryBlob || got != size {
+			t.Errorf("Size: %d, decoded type %d, size %d", size, header[0].Type(), got)
+		}
+	}
+}
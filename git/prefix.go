@@ -0,0 +1,178 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// minPrefixLen is the shortest prefix accepted as an abbreviated id.
+const minPrefixLen = 4
+
+var ErrInvalidPrefix = errors.New("Invalid object id prefix")
+
+// AmbiguousPrefixError is returned if an abbreviated id matches more than one
+// object.
+type AmbiguousPrefixError struct {
+	Prefix     string
+	Candidates []SHA1
+}
+
+func (e *AmbiguousPrefixError) Error() string {
+	candidates := make([]string, len(e.Candidates))
+	for i, id := range e.Candidates {
+		candidates[i] = id.String()
+	}
+	return fmt.Sprintf("Ambiguous object id %s: %s", e.Prefix, strings.Join(candidates, ", "))
+}
+
+// prefixFinder is implemented by an ObjectStore which can find objects by a
+// prefix of ids without scanning all of objects.
+type prefixFinder interface {
+	findPrefix(prefix string) ([]SHA1, error)
+}
+
+// fanoutIndex is implemented by a PackIndex which keeps sorted ids in memory
+// together with the fanout table.
+type fanoutIndex interface {
+	fanout() (*[256]uint32, []SHA1)
+}
+
+// ResolvePrefix resolves an abbreviated hex id which has at least 4 digits.
+// *AmbiguousPrefixError is returned if more than one object matches, and
+// ErrObjectNotFound if nothing matches.
+func (r *Repository) ResolvePrefix(prefix string) (SHA1, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < minPrefixLen || len(prefix) > r.hash.Size*2 || strings.Trim(prefix, "0123456789abcdef") != "" {
+		return SHA1{}, ErrInvalidPrefix
+	}
+	ids, err := r.findPrefix(prefix)
+	if err != nil {
+		return SHA1{}, err
+	}
+	switch len(ids) {
+	case 0:
+		return SHA1{}, ErrObjectNotFound
+	case 1:
+		return ids[0], nil
+	}
+	return SHA1{}, &AmbiguousPrefixError{prefix, ids}
+}
+
+// Abbrev returns the shortest prefix of id which is unique in the repository
+// and has at least minLen digits. minLen shorter than 4 is treated as 4.
+func (r *Repository) Abbrev(id SHA1, minLen int) (string, error) {
+	if minLen < minPrefixLen {
+		minLen = minPrefixLen
+	}
+	s := id.String()
+	for n := minLen; n < len(s); n++ {
+		ids, err := r.findPrefix(s[:n])
+		if err != nil {
+			return "", err
+		}
+		if len(ids) == 0 || len(ids) == 1 && ids[0] == id {
+			return s[:n], nil
+		}
+	}
+	return s, nil
+}
+
+// findPrefix returns ids which have the prefix in ascending order.
+func (r *Repository) findPrefix(prefix string) ([]SHA1, error) {
+	var ids []SHA1
+	if f, ok := r.store.(prefixFinder); ok {
+		var err error
+		if ids, err = f.findPrefix(prefix); err != nil {
+			return nil, err
+		}
+	} else {
+		err := r.store.ForEach(func(id SHA1) error {
+			if strings.HasPrefix(id.String(), prefix) {
+				ids = append(ids, id)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Sort(bySHA1(ids))
+	return ids, nil
+}
+
+func (s *fsObjectStore) findPrefix(prefix string) ([]SHA1, error) {
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	found := make(map[SHA1]bool)
+	for retried := false; ; retried = true {
+		for _, dir := range s.dirs {
+			if err := dir.findPrefix(prefix, found); err != nil {
+				return nil, err
+			}
+		}
+		if len(found) > 0 || retried {
+			break
+		}
+		if ok, err := s.rescan(); err != nil {
+			return nil, err
+		} else if !ok {
+			break
+		}
+	}
+	ids := make([]SHA1, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// findPrefix adds ids which have the prefix to found. Pack indexes are binary
+// searched within the range narrowed by the fanout table, and only one fan-out
+// directory is read for loose objects.
+func (d *objectDir) findPrefix(prefix string, found map[SHA1]bool) error {
+	lower := SHA1FromHexString(prefix + strings.Repeat("0", d.hash.Size*2-len(prefix)))
+	for _, pack := range d.packs {
+		ids := indexIDs(pack.idx, lower.hash[0])
+		i := sort.Search(len(ids), func(i int) bool { return ids[i].Compare(lower) >= 0 })
+		for ; i < len(ids) && strings.HasPrefix(ids[i].String(), prefix); i++ {
+			found[ids[i]] = true
+		}
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(d.path, prefix[:2]))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), prefix[2:]) {
+			continue
+		}
+		if id, ok := parseLooseObjectPath(prefix[:2], file.Name()); ok {
+			found[id] = true
+		}
+	}
+	return nil
+}
+
+// indexIDs returns sorted ids in idx which include all ids whose first byte is
+// first. Only those ids are returned if idx has the fanout table in memory.
+func indexIDs(idx PackIndex, first byte) []SHA1 {
+	if idx, ok := idx.(fanoutIndex); ok {
+		fanout, objects := idx.fanout()
+		_, ids := fanoutRange(fanout, objects, first)
+		return ids
+	}
+	ids := make([]SHA1, idx.Len())
+	for i := range ids {
+		ids[i] = idx.EntryAt(i).ID
+	}
+	return ids
+}
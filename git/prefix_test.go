@@ -0,0 +1,102 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolvePrefix(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	// Find two blobs sharing the first 4 digits.
+	seen := make(map[string]SHA1)
+	var a, b SHA1
+	for i := 0; a.Empty(); i++ {
+		blob := repo.NewBlob(bytes.NewReader([]byte(fmt.Sprintf("blob %d\n", i))))
+		if err := blob.Write(); err != nil {
+			t.Fatal(err)
+		}
+		prefix := blob.SHA1().String()[:4]
+		if id, ok := seen[prefix]; ok {
+			a, b = id, blob.SHA1()
+		}
+		seen[prefix] = blob.SHA1()
+	}
+	// One of them is packed.
+	w := NewPackWriter(repo)
+	w.Add(a)
+	if _, err := w.Save(filepath.Join(dir, "objects", "pack")); err != nil {
+		t.Fatal(err)
+	}
+	h := a.String()
+	os.Remove(filepath.Join(dir, "objects", h[:2], h[2:]))
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repo.ResolvePrefix(a.String()[:4])
+	if e, ok := err.(*AmbiguousPrefixError); !ok || len(e.Candidates) != 2 {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, id := range []SHA1{a, b} {
+		abbrev, err := repo.Abbrev(id, 0)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(abbrev) <= 4 {
+			t.Errorf("Abbreviation is too short: %s", abbrev)
+		}
+		for _, prefix := range []string{abbrev, id.String(), id.String()[:len(abbrev)+1]} {
+			if resolved, err := repo.ResolvePrefix(prefix); err != nil || resolved != id {
+				t.Errorf("%s: Unexpected result: %s, %v", prefix, resolved, err)
+			}
+		}
+	}
+	if abbrev, err := repo.Abbrev(a, 10); err != nil || len(abbrev) != 10 {
+		t.Errorf("Unexpected abbreviation: %s, %v", abbrev, err)
+	}
+
+	for _, tc := range []struct {
+		prefix string
+		err    error
+	}{
+		{"abc", ErrInvalidPrefix},
+		{"xyzw", ErrInvalidPrefix},
+		{a.String() + "0", ErrInvalidPrefix},
+		{"00000", ErrObjectNotFound},
+	} {
+		if _, err := repo.ResolvePrefix(tc.prefix); err != tc.err {
+			t.Errorf("%s: Unexpected error: %v", tc.prefix, err)
+		}
+	}
+}
+
+func TestIndexIDs(t *testing.T) {
+	idx := new(PackIndexV2)
+	for _, first := range []byte{0x00, 0x12, 0x12, 0x12, 0x34, 0xff} {
+		id := SHA1FromBytes([]byte{first, byte(len(idx.Objects))})
+		idx.Objects = append(idx.Objects, id)
+		for i := int(first); i < len(idx.Fanout); i++ {
+			idx.Fanout[i]++
+		}
+	}
+	for _, tc := range []struct {
+		first byte
+		n     int
+	}{
+		{0x00, 1}, {0x11, 0}, {0x12, 3}, {0x34, 1}, {0xff, 1},
+	} {
+		ids := indexIDs(idx, tc.first)
+		if len(ids) != tc.n {
+			t.Errorf("%02x: Unexpected ids: %v", tc.first, ids)
+		}
+		for _, id := range ids {
+			if id.hash[0] != tc.first {
+				t.Errorf("%02x: Unexpected id: %s", tc.first, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package git
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrPromisedObjectMissing is returned for an object missing in a partial
+// clone. Unlike ErrObjectNotFound, it's not a corruption because the promisor
+// remote is expected to have the object.
+var ErrPromisedObjectMissing = errors.New("Promised object is missing")
+
+const (
+	// maxFetchedObjects and maxFetchedSize limit objects fetched lazily and
+	// buffered in memory until they're stored in a promisor pack.
+	maxFetchedObjects = 1024
+	maxFetchedSize    = 1 << 24
+)
+
+// ObjectFetcher fetches objects missing in a partial clone, typically from the
+// promisor remote.
+type ObjectFetcher interface {
+	// FetchObject returns the object of id. It's called only for objects
+	// which are not in the repository.
+	FetchObject(id SHA1) (ObjectEntry, error)
+}
+
+// ObjectFetcherFunc is an adapter to use a function as an ObjectFetcher. For
+// example, (*MemoryObjectStore).Entry can be used as an ObjectFetcher.
+type ObjectFetcherFunc func(id SHA1) (ObjectEntry, error)
+
+func (f ObjectFetcherFunc) FetchObject(id SHA1) (ObjectEntry, error) {
+	return f(id)
+}
+
+// IsPartialClone reports whether the repository is a partial clone, where
+// objects may be missing. It's configured by extensions.partialClone or
+// remote.<name>.promisor in recent versions of git.
+func (r *Repository) IsPartialClone() bool {
+	if _, ok := r.config.Get("extensions.partialClone"); ok {
+		return true
+	}
+	for _, e := range r.config.entries {
+		if strings.HasPrefix(e.key, "remote.") && strings.HasSuffix(e.key, ".promisor") {
+			if promisor, _ := r.config.Bool(e.key, false); promisor {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetObjectFetcher registers the fetcher of missing objects in a partial
+// clone. Fetched objects are verified and buffered, then stored together in a
+// new promisor pack by FlushFetchedObjects, so they're fetched only once.
+// Callers must call Close or FlushFetchedObjects when they finish reading,
+// otherwise objects still buffered are lost and fetched again later.
+// Without a fetcher, ErrPromisedObjectMissing is returned for missing objects.
+func (r *Repository) SetObjectFetcher(f ObjectFetcher) {
+	r.fetcher = f
+}
+
+// isPromised reports whether a missing object is expected to be fetched later.
+func (r *Repository) isPromised(err error) bool {
+	return err == ErrObjectNotFound && r.IsPartialClone()
+}
+
+// FlushFetchedObjects stores objects fetched lazily into a single promisor
+// pack. It's called automatically when many objects are buffered, and by
+// Close.
+func (r *Repository) FlushFetchedObjects() error {
+	if len(r.fetched) == 0 {
+		return nil
+	}
+	if err := r.storePromisorObjects(r.fetched); err != nil {
+		return err
+	}
+	r.fetched, r.fetchedSize = nil, 0
+	return nil
+}
+
+// fetchObject fetches a missing object with the registered fetcher.
+func (r *Repository) fetchObject(id SHA1) (ObjectEntry, error) {
+	if entry, ok := r.fetched[id]; ok {
+		return entry, nil
+	}
+	if r.fetcher == nil {
+		return nil, ErrPromisedObjectMissing
+	}
+	entry, err := r.fetcher.FetchObject(id)
+	if err != nil {
+		return nil, err
+	}
+	defer entry.Close()
+	typ := entry.Type()
+	b, err := entry.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	data := append([]byte(nil), b...)
+	if r.hash.hashObject(typ, data) != id {
+		return nil, &FsckError{id, typ, ErrHashMismatch}
+	}
+
+	fetched := &memoryEntry{typ: typ, data: data}
+	if r.fetched == nil {
+		r.fetched = make(map[SHA1]*memoryEntry)
+	}
+	r.fetched[id] = fetched
+	r.fetchedSize += len(data)
+	if len(r.fetched) >= maxFetchedObjects || r.fetchedSize >= maxFetchedSize {
+		if err = r.FlushFetchedObjects(); err != nil {
+			return nil, err
+		}
+	}
+	return fetched, nil
+}
+
+// storePromisorObjects stores fetched objects in a pack marked with a .promisor
+// file like git does, so that objects they refer are also promised.
+func (r *Repository) storePromisorObjects(objects map[SHA1]*memoryEntry) error {
+	ids := make([]SHA1, 0, len(objects))
+	for id := range objects {
+		ids = append(ids, id)
+	}
+	sort.Sort(bySHA1(ids))
+	store, ok := r.store.(*fsObjectStore)
+	if !ok {
+		for _, id := range ids {
+			if _, err := r.store.Put(objects[id].typ, bytes.NewReader(objects[id].data)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.BigEndian, &PackHeader{Magic: packMagic, Version: 2, Total: uint32(len(ids))})
+	for _, id := range ids {
+		t, err := packEntryTypeOf(objects[id].typ)
+		if err != nil {
+			return err
+		}
+		buf.Write(encodePackEntryHeader(t, int64(len(objects[id].data))))
+		if err = writeCompressed(buf, objects[id].data); err != nil {
+			return err
+		}
+	}
+	hasher := r.hash.New()
+	hasher.Write(buf.Bytes())
+	checksum := r.hash.Sum(hasher)
+	buf.Write(checksum.Bytes())
+
+	// The .promisor file is created first so that the pack is never seen
+	// without it.
+	promisor := filepath.Join(store.path, "pack", "pack-"+checksum.String()+".promisor")
+	if err := ioutil.WriteFile(promisor, nil, 0444); err != nil {
+		return err
+	}
+	if _, err := r.IndexPack(buf); err != nil {
+		os.Remove(promisor)
+		return err
+	}
+	return nil
+}
+
+// isPromisor reports whether the pack is fetched from a promisor remote.
+// Objects referred by objects in such a pack may be missing.
+func (p *Pack) isPromisor() bool {
+	_, err := os.Stat(p.basePath() + ".promisor")
+	return err == nil
+}
+
+// promisorObjects returns ids of objects in promisor packs, including fetched
+// objects yet to be stored.
+func (r *Repository) promisorObjects() map[SHA1]bool {
+	objects := make(map[SHA1]bool)
+	for id := range r.fetched {
+		objects[id] = true
+	}
+	store, ok := r.store.(*fsObjectStore)
+	if !ok || store.open() != nil {
+		return objects
+	}
+	for _, dir := range store.dirs {
+		for _, pack := range dir.packs {
+			if pack.isPromisor() {
+				forEachPackObject(pack, func(id SHA1) { objects[id] = true })
+			}
+		}
+	}
+	return objects
+}
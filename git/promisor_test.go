@@ -0,0 +1,165 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestPartialClone(t *testing.T) {
+	// The remote has all objects, and the clone has all but the blobs.
+	remote := NewMemoryObjectStore(nil)
+	src := NewRepository(remote, nil)
+	blob := src.NewBlob(bytes.NewReader([]byte("hello\n")))
+	blob2 := src.NewBlob(bytes.NewReader([]byte("world\n")))
+	tree := src.NewTree()
+	tree.Add("hello.txt", blob, ModeFile)
+	tree.Add("world.txt", blob2, ModeFile)
+	if err := tree.Write(); err != nil {
+		t.Fatal(err)
+	}
+	user := NewUser("go-git", "go-git@example.com")
+	commit := src.NewCommit(tree, nil, user, user, "init\n")
+	if err := commit.Write(); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, dir := newTestRepo(t, true)
+	w := NewPackWriter(src)
+	w.Add(tree.SHA1(), commit.SHA1())
+	path, err := w.Save(filepath.Join(dir, "objects", "pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = repo.NewRef(BranchRef("master"), commit.SHA1()).Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.Blob(blob.SHA1()); err != ErrObjectNotFound {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	promisor := path[:len(path)-len(".pack")] + ".promisor"
+	if err = ioutil.WriteFile(promisor, nil, 0444); err != nil {
+		t.Fatal(err)
+	}
+	config := "[core]\n\trepositoryformatversion = 1\n[extensions]\n\tpartialClone = origin\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, "config"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !repo.IsPartialClone() {
+		t.Fatalf("Not a partial clone")
+	}
+	if _, err = repo.Blob(blob.SHA1()); err != ErrPromisedObjectMissing {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	objs, err := repo.ReachableObjects([]SHA1{commit.SHA1()})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(objs) != 4 {
+		t.Errorf("Unexpected number of objects: %d", len(objs))
+	}
+	result, err := repo.Fsck()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.OK() || len(result.Promised) != 2 {
+		t.Errorf("Unexpected fsck result: %+v", result)
+	}
+
+	// A fetcher returning a wrong object is rejected.
+	repo.SetObjectFetcher(ObjectFetcherFunc(func(id SHA1) (ObjectEntry, error) {
+		return remote.Entry(tree.SHA1())
+	}))
+	if _, err = repo.Blob(blob.SHA1()); err == nil {
+		t.Fatalf("Expected error for a wrong object")
+	}
+
+	repo.SetObjectFetcher(ObjectFetcherFunc(remote.Entry))
+	b, err := repo.Blob(blob.SHA1())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if string(b.Data) != "hello\n" {
+		t.Errorf("Unexpected data: %q", b.Data)
+	}
+
+	if _, err = repo.Blob(blob2.SHA1()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Fetched objects are buffered, then stored in a single promisor pack.
+	repo.SetObjectFetcher(nil)
+	if _, err = repo.Blob(blob.SHA1()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	promisors := filepath.Join(dir, "objects", "pack", "*.promisor")
+	if files, _ := filepath.Glob(promisors); len(files) != 1 {
+		t.Errorf("Unexpected promisor files: %v", files)
+	}
+	if err = repo.FlushFetchedObjects(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	files, _ := filepath.Glob(promisors)
+	if len(files) != 2 {
+		t.Fatalf("Unexpected promisor files: %v", files)
+	}
+	for _, file := range files {
+		if file == promisor {
+			continue
+		}
+		pack, err := OpenPack(file[:len(file)-len(".promisor")] + ".pack")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if pack.idx.Len() != 2 {
+			t.Errorf("Unexpected number of objects: %d", pack.idx.Len())
+		}
+		pack.Close()
+	}
+	for _, id := range []SHA1{blob.SHA1(), blob2.SHA1()} {
+		if _, err = repo.Blob(id); err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if result, err = repo.Fsck(); err != nil || !result.OK() || len(result.Promised) != 0 {
+		t.Errorf("Unexpected fsck result: %+v, %v", result, err)
+	}
+
+	// A missing object not referred from promisor packs is not promised.
+	lost := src.NewCommit(tree, nil, user, user, "lost\n")
+	if err = lost.Write(); err != nil {
+		t.Fatal(err)
+	}
+	child := repo.NewCommit(tree, []*Commit{lost}, user, user, "child\n")
+	if err = child.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.ReachableObjects([]SHA1{child.SHA1()}); err != ErrObjectNotFound {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// Objects fetched below the threshold are stored by Close.
+	blob3 := src.NewBlob(bytes.NewReader([]byte("again\n")))
+	if err = blob3.Write(); err != nil {
+		t.Fatal(err)
+	}
+	repo.SetObjectFetcher(ObjectFetcherFunc(remote.Entry))
+	if _, err = repo.Blob(blob3.SHA1()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err = repo.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if repo, err = Open(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.Blob(blob3.SHA1()); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
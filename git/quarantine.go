@@ -0,0 +1,191 @@
+package git
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var ErrTransactionClosed = errors.New("Transaction is already committed or rolled back")
+
+// ObjectTransaction writes objects into a quarantine directory like
+// GIT_QUARANTINE_PATH of git, so that a batch of objects is added to the
+// repository all together or not at all.
+type ObjectTransaction struct {
+	repo   *Repository
+	main   *fsObjectStore
+	store  *fsObjectStore
+	path   string
+	closed bool
+}
+
+// BeginObjectTransaction creates a quarantine directory objects/incoming-*
+// and starts a transaction writing new objects into it. Objects written
+// through the transaction's repository are not visible from others until
+// Commit is called.
+func (r *Repository) BeginObjectTransaction() (*ObjectTransaction, error) {
+	store, ok := r.store.(*fsObjectStore)
+	if !ok {
+		return nil, ErrNotSupported
+	}
+	path, err := ioutil.TempDir(store.path, "incoming-")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Mkdir(filepath.Join(path, "pack"), 0777); err != nil {
+		os.RemoveAll(path)
+		return nil, err
+	}
+
+	quarantine := newFSObjectStore(path, store.hash)
+	quarantine.alternates = []string{store.path}
+	quarantine.compression = store.compression
+	quarantine.fsync = store.fsync
+	repo := *r
+	repo.store = quarantine
+	// Lazily fetched objects are buffered separately.
+	repo.fetched, repo.fetchedSize = nil, 0
+	return &ObjectTransaction{
+		repo:  &repo,
+		main:  store,
+		store: quarantine,
+		path:  path,
+	}, nil
+}
+
+// Repository returns the repository to write objects in the transaction. It
+// reads objects both in the quarantine and the repository, and shares refs
+// with the repository.
+func (tx *ObjectTransaction) Repository() *Repository {
+	return tx.repo
+}
+
+// Commit migrates objects in the quarantine into the repository and removes
+// the quarantine directory. Loose objects are moved first, then packs are
+// moved so that an index never appears before its pack.
+//
+// If it fails, the quarantine is left with objects not migrated yet and the
+// transaction remains open, so that Commit can be retried or Rollback can
+// discard them.
+func (tx *ObjectTransaction) Commit() error {
+	if tx.closed {
+		return ErrTransactionClosed
+	}
+	// Objects fetched in the transaction are migrated together.
+	if err := tx.repo.FlushFetchedObjects(); err != nil {
+		return err
+	}
+	tx.store.close()
+	if err := tx.migrate(); err != nil {
+		return err
+	}
+	tx.closed = true
+
+	// Pick up the migrated packs if the repository has opened packs.
+	if tx.main.dirs != nil {
+		if err := tx.main.dirs[0].openPack(); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(tx.path)
+}
+
+// migrate moves objects in the quarantine into the repository.
+func (tx *ObjectTransaction) migrate() error {
+	dest := tx.main.path
+	dirs, err := ioutil.ReadDir(tx.path)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(tx.path, dir.Name()))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if _, ok := parseLooseObjectPath(dir.Name(), file.Name()); !ok {
+				continue
+			}
+			if err = os.MkdirAll(filepath.Join(dest, dir.Name()), 0777); err != nil {
+				return err
+			}
+			err = migrateObjectFile(filepath.Join(tx.path, dir.Name(), file.Name()), filepath.Join(dest, dir.Name(), file.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(tx.path, "pack"))
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), "pack-") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Sort(byPackFilePriority(names))
+	for _, name := range names {
+		if err = migrateObjectFile(filepath.Join(tx.path, "pack", name), filepath.Join(dest, "pack", name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback discards all objects written in the transaction.
+func (tx *ObjectTransaction) Rollback() error {
+	if tx.closed {
+		return ErrTransactionClosed
+	}
+	tx.closed = true
+	tx.store.close()
+	return os.RemoveAll(tx.path)
+}
+
+// migrateObjectFile moves an object file into the repository. The file which
+// already exists is left as is since it has the same content.
+func migrateObjectFile(src, dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return os.Remove(src)
+	}
+	return os.Rename(src, dest)
+}
+
+// byPackFilePriority sorts files in a pack directory in the order to be moved.
+// It's the same as git, .keep, .pack, .rev and .idx in this order, except that
+// .promisor files are moved together with .keep files, so that a promisor pack
+// is never seen without its .promisor file.
+type byPackFilePriority []string
+
+func (z byPackFilePriority) Len() int      { return len(z) }
+func (z byPackFilePriority) Swap(i, j int) { z[i], z[j] = z[j], z[i] }
+func (z byPackFilePriority) Less(i, j int) bool {
+	pi, pj := packFilePriority(z[i]), packFilePriority(z[j])
+	if pi != pj {
+		return pi < pj
+	}
+	return z[i] < z[j]
+}
+
+func packFilePriority(name string) int {
+	switch filepath.Ext(name) {
+	case ".keep", ".promisor":
+		return 0
+	case ".pack":
+		return 1
+	case ".rev":
+		return 2
+	case ".idx":
+		return 3
+	}
+	return 4
+}
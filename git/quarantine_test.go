@@ -0,0 +1,144 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestObjectTransaction(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	base := newTestObjects(t, repo, 3)
+	if !repo.store.Has(base[0]) {
+		t.Fatalf("Object %s is not found", base[0])
+	}
+
+	tx, err := repo.BeginObjectTransaction()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	txRepo := tx.Repository()
+	if !txRepo.store.Has(base[0]) {
+		t.Errorf("Object %s is not visible in the transaction", base[0])
+	}
+	blob := txRepo.NewBlob(bytes.NewReader([]byte("quarantined\n")))
+	if err = blob.Write(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !txRepo.store.Has(blob.SHA1()) {
+		t.Errorf("Object %s is not visible in the transaction", blob.SHA1())
+	}
+	if repo.store.Has(blob.SHA1()) {
+		t.Errorf("Object %s is visible before commit", blob.SHA1())
+	}
+
+	// A pack indexed in the transaction is also quarantined.
+	src := NewRepository(NewMemoryObjectStore(nil), nil)
+	ids := newTestObjects(t, src, 2)
+	w := NewPackWriter(src)
+	w.Add(ids...)
+	buf := new(bytes.Buffer)
+	if _, err = w.Encode(buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = txRepo.IndexPack(buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Blobs are shared with the repository, but the tree and the commit are new.
+	for _, id := range ids[len(ids)-2:] {
+		if repo.store.Has(id) {
+			t.Errorf("Object %s is visible before commit", id)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for _, id := range append(ids, blob.SHA1()) {
+		if !repo.store.Has(id) {
+			t.Errorf("Object %s is not migrated", id)
+		}
+	}
+	if b, err := repo.Blob(blob.SHA1()); err != nil || string(b.Data) != "quarantined\n" {
+		t.Errorf("Unexpected blob: %v", err)
+	}
+	if err = tx.Commit(); err != ErrTransactionClosed {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	assertNoQuarantine(t, dir)
+
+	tx, err = repo.BeginObjectTransaction()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	blob = tx.Repository().NewBlob(bytes.NewReader([]byte("discarded\n")))
+	if err = blob.Write(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if repo.store.Has(blob.SHA1()) {
+		t.Errorf("Object %s is left after rollback", blob.SHA1())
+	}
+	if err = tx.Rollback(); err != ErrTransactionClosed {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	assertNoQuarantine(t, dir)
+}
+
+func assertNoQuarantine(t *testing.T, dir string) {
+	matches, err := filepath.Glob(filepath.Join(dir, "objects", "incoming-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 0 {
+		t.Errorf("Quarantine is left: %v", matches)
+	}
+}
+
+func TestObjectTransactionCommitError(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	for _, rollback := range []bool{false, true} {
+		tx, err := repo.BeginObjectTransaction()
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		blob := tx.Repository().NewBlob(bytes.NewReader([]byte(fmt.Sprintf("rollback %v\n", rollback))))
+		if err = blob.Write(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		// A file in place of the fan-out directory makes the migration fail.
+		h := blob.SHA1().String()
+		blocker := filepath.Join(dir, "objects", h[:2])
+		if err = ioutil.WriteFile(blocker, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err = tx.Commit(); err == nil {
+			t.Fatalf("Expected error for a failed migration")
+		}
+		if !tx.Repository().store.Has(blob.SHA1()) {
+			t.Errorf("Object %s is discarded", blob.SHA1())
+		}
+		if err = os.Remove(blocker); err != nil {
+			t.Fatal(err)
+		}
+
+		if rollback {
+			err = tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if found := repo.store.Has(blob.SHA1()); found == rollback {
+			t.Errorf("Unexpected existence of %s: %v", blob.SHA1(), found)
+		}
+		assertNoQuarantine(t, dir)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -18,7 +19,7 @@ type Ref struct {
 }
 
 func (r *Ref) Write() error {
-	path := filepath.Join(r.repo.root, r.Name)
+	path := r.repo.refPath(r.Name)
 	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
 		return err
 	}
@@ -29,7 +30,7 @@ func (r *Ref) Delete() error {
 	if r.Name == "" {
 		return nil
 	}
-	err := os.Remove(filepath.Join(r.repo.root, r.Name))
+	err := os.Remove(r.repo.refPath(r.Name))
 	if os.IsNotExist(err) {
 		return nil
 	}
@@ -116,19 +117,73 @@ func (r *Repository) FindRef(name string) (*Ref, error) {
 	return nil, fmt.Errorf("Ref not found: %s", name)
 }
 
-func (r *Repository) looseRef(name string) (*Ref, error) {
-	f, err := os.Open(filepath.Join(r.root, name))
-	if err != nil {
-		return nil, err
+// worktreeRefPrefixes are directories of refs private to each worktree.
+var worktreeRefPrefixes = []string{"refs/worktree/", "refs/bisect/"}
+
+// refPath returns the file path of the loose ref. In a linked worktree, HEAD
+// and refs under refs/worktree and refs/bisect are private to the worktree and
+// others are shared.
+func (r *Repository) refPath(name string) string {
+	return r.refPathIn(r.root, name)
+}
+
+// refPathIn returns the file path of the loose ref seen from the worktree
+// whose git directory is root.
+func (r *Repository) refPathIn(root, name string) string {
+	if !strings.HasPrefix(name, "refs/") || isWorktreeRef(name) {
+		return filepath.Join(root, name)
 	}
-	defer f.Close()
+	return filepath.Join(r.commonDir(), name)
+}
 
-	buf := bufio.NewReader(f)
-	b, err := buf.ReadBytes('\n')
-	if err != nil {
-		return nil, err
+func isWorktreeRef(name string) bool {
+	for _, prefix := range worktreeRefPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
 	}
-	return r.NewRef(name, SHA1FromHex(b[:len(b)-1])), nil
+	return false
+}
+
+// maxSymrefDepth is the limit of nested symbolic refs same as git.
+const maxSymrefDepth = 5
+
+// looseRef reads the loose ref of name. A symbolic ref like
+// refs/remotes/origin/HEAD is resolved to the id of the ref it points.
+func (r *Repository) looseRef(name string) (*Ref, error) {
+	return r.looseRefIn(r.root, name)
+}
+
+// looseRefIn reads the loose ref of name seen from the worktree whose git
+// directory is root.
+func (r *Repository) looseRefIn(root, name string) (*Ref, error) {
+	target := name
+	for depth := 0; depth <= maxSymrefDepth; depth++ {
+		f, err := os.Open(r.refPathIn(root, target))
+		if err != nil {
+			return nil, err
+		}
+		b, err := bufio.NewReader(f).ReadBytes('\n')
+		f.Close()
+		if err != nil && (err != io.EOF || len(b) == 0) {
+			return nil, err
+		}
+		b = bytes.TrimSpace(b)
+		if !bytes.HasPrefix(b, []byte("ref: ")) {
+			id, err := NewSHA1(string(b))
+			if err != nil {
+				return nil, ErrUnknownFormat
+			}
+			return r.NewRef(name, id), nil
+		}
+		target = string(b[5:])
+		if ref := r.packedRefs.Ref(target); ref != nil {
+			if _, err := os.Stat(r.refPathIn(root, target)); os.IsNotExist(err) {
+				return r.NewRef(name, ref.SHA1), nil
+			}
+		}
+	}
+	return nil, ErrUnknownFormat
 }
 
 func (r *Repository) Branches() []*Ref {
@@ -152,14 +207,13 @@ func (r Repository) Tags() []*Ref {
 }
 
 func (r Repository) looseRefs(path string) ([]*Ref, error) {
-	path = filepath.Join(r.root, path)
-	files, err := ioutil.ReadDir(path)
+	files, err := ioutil.ReadDir(r.refPath(path))
 	if err != nil {
 		return nil, err
 	}
 	var refs []*Ref
 	for _, file := range files {
-		name := filepath.Join(path, file.Name())
+		name := filepath.ToSlash(filepath.Join(path, file.Name()))
 		if ref, err := r.Ref(name); err == nil {
 			refs = append(refs, ref)
 		}
@@ -167,6 +221,82 @@ func (r Repository) looseRefs(path string) ([]*Ref, error) {
 	return refs, nil
 }
 
+// Refs returns all refs under refs/ including nested ones like
+// refs/heads/feature/x, whether they're packed or not. Refs private to other
+// worktrees are not included. An error is returned if any ref can't be read,
+// so that the result is never incomplete silently. Symbolic refs pointing to
+// nothing are skipped.
+func (r *Repository) Refs() ([]*Ref, error) {
+	refs := r.packedRefs.Refs("refs/")
+	if err := r.packedRefs.Err; err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	loose, err := r.looseRefsIn(r.root, r.commonDir(), "refs/")
+	if err != nil {
+		return nil, err
+	}
+	private, err := r.worktreeRefs(r.root)
+	if err != nil {
+		return nil, err
+	}
+	return Refs(refs).merge(append(loose, private...)), nil
+}
+
+// worktreeRefs returns loose refs private to the worktree whose git directory
+// is root.
+func (r *Repository) worktreeRefs(root string) ([]*Ref, error) {
+	var refs []*Ref
+	for _, prefix := range worktreeRefPrefixes {
+		loose, err := r.looseRefsIn(root, root, prefix)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, loose...)
+	}
+	return refs, nil
+}
+
+// looseRefsIn reads all loose refs under prefix in dir seen from the worktree
+// whose git directory is root. Private refs of worktrees are read only if dir
+// is root. Refs removed while reading and lock files of refs being updated are
+// skipped.
+func (r *Repository) looseRefsIn(root, dir, prefix string) ([]*Ref, error) {
+	var refs []*Ref
+	err := filepath.Walk(filepath.Join(dir, prefix), func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if info.IsDir() {
+			if dir != root && isWorktreeRef(name+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(name, ".lock") {
+			return nil
+		}
+		ref, err := r.looseRefIn(root, name)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Bad ref %s: %v", name, err)
+		}
+		refs = append(refs, ref)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
 func (r *Repository) Head() (*Ref, error) {
 	f, err := os.Open(filepath.Join(r.root, "HEAD"))
 	if err != nil {
@@ -260,7 +390,7 @@ func (p *PackedRefs) Parse() error {
 func (r *Repository) openPackedRefs() {
 	r.packedRefs = &PackedRefs{
 		repo: r,
-		Path: filepath.Join(r.root, "packed-refs"),
+		Path: filepath.Join(r.commonDir(), "packed-refs"),
 	}
 }
 
@@ -1,6 +1,8 @@
 package git
 
 import (
+	"io/ioutil"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -37,3 +39,38 @@ func TestCandidateRefs(t *testing.T) {
 		}
 	}
 }
+
+func TestSymbolicRef(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	id := SHA1FromBytes([]byte{1})
+	if err := repo.NewRef("refs/remotes/origin/main", id).Write(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "refs", "remotes", "origin", "HEAD")
+	if err := ioutil.WriteFile(path, []byte("ref: refs/remotes/origin/main\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	ref, err := repo.Ref("refs/remotes/origin/HEAD")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if ref.SHA1 != id {
+		t.Errorf("Unexpected id: %s", ref.SHA1)
+	}
+	refs, err := repo.Refs()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(refs) != 2 {
+		t.Errorf("Unexpected refs: %v", refs)
+	}
+
+	// A cycle of symbolic refs is an error.
+	if err = ioutil.WriteFile(path, []byte("ref: refs/remotes/origin/HEAD\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.Ref("refs/remotes/origin/HEAD"); err == nil {
+		t.Errorf("Expected error for a cycle of symbolic refs")
+	}
+}
@@ -1,24 +1,35 @@
 package git
 
 import (
-	"compress/zlib"
-	"crypto/sha1"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
+// Repository is a git repository. It's not safe for concurrent use, since
+// even reading objects updates caches and may buffer lazily fetched objects.
 type Repository struct {
-	Path       string
-	Bare       bool
-	root       string
-	packs      []*Pack
-	packedRefs *PackedRefs
+	Path           string
+	Bare           bool
+	root           string
+	common         string
+	store          ObjectStore
+	config         *Config
+	hash           *HashAlgo
+	packedRefs     *PackedRefs
+	commitGraph    *CommitGraph
+	commitGraphErr error
+	shallow        map[SHA1]bool
+	shallowErr     error
+	fetcher        ObjectFetcher
+	fetched        map[SHA1]*memoryEntry
+	fetchedSize    int
 }
 
+// Open opens the repository at path. path must be the top-level directory of
+// a work tree or a git directory itself. Use Discover to find the repository
+// from any subdirectory.
 func Open(path string) (*Repository, error) {
 	path = filepath.Clean(path)
 	fi, err := os.Stat(path)
@@ -29,31 +40,105 @@ func Open(path string) (*Repository, error) {
 		return nil, fmt.Errorf("Not a git repository: %s", path)
 	}
 
+	root, err := dotGitAt(path)
+	if err != nil {
+		return nil, err
+	}
+	if root != "" {
+		return newRepository(path, root, false)
+	}
+	if isGitDir(path) {
+		return newRepository(path, path, true)
+	}
+	return nil, fmt.Errorf("Not a git repository: %s", path)
+}
+
+func newRepository(path, root string, bare bool) (*Repository, error) {
 	repo := &Repository{
-		Path: path,
-		root: path,
+		Path:   path,
+		Bare:   bare,
+		root:   root,
+		common: readCommonDir(root),
 	}
-	defer func() {
-		if repo != nil {
-			repo.openPackedRefs()
-		}
-	}()
-	if strings.HasSuffix(path, ".git") {
-		repo.Bare = true
-		return repo, nil
+	var err error
+	if repo.config, err = readConfig(repo.commonDir()); err != nil {
+		return nil, err
+	}
+	if repo.hash, err = objectFormat(repo.config); err != nil {
+		return nil, err
+	}
+	store := newFSObjectStore(repo.objectsPath(), repo.hash)
+	if err = store.configure(repo.config); err != nil {
+		return nil, err
 	}
+	repo.store = store
+	repo.openPackedRefs()
+	return repo, nil
+}
 
-	files, err := ioutil.ReadDir(path)
+// objectFormat returns the hash algorithm of the repository configured by
+// extensions.objectFormat. Extensions are recognized only in the repository
+// format version 1.
+func objectFormat(config *Config) (*HashAlgo, error) {
+	version, err := config.Int("core.repositoryFormatVersion", 0)
 	if err != nil {
 		return nil, err
 	}
-	for _, file := range files {
-		if file.Name() == ".git" {
-			repo.root = filepath.Join(repo.root, ".git")
-			return repo, nil
-		}
+	switch version {
+	case 0:
+		return HashSHA1, nil
+	case 1:
+	default:
+		return nil, fmt.Errorf("Unknown repository format version: %d", version)
 	}
-	return nil, fmt.Errorf("Not a git repository: %s", path)
+	if name, ok := config.Get("extensions.objectFormat"); ok {
+		return hashAlgoByName(strings.ToLower(name))
+	}
+	return HashSHA1, nil
+}
+
+// NewRepository returns a repository which reads and writes objects only
+// through store. It has no files on disk, so refs are not available. h is the
+// hash algorithm of object ids in store, and SHA-1 is used if h is nil.
+func NewRepository(store ObjectStore, h *HashAlgo) *Repository {
+	return &Repository{
+		Bare:   true,
+		store:  store,
+		config: new(Config),
+		hash:   h.orSHA1(),
+	}
+}
+
+// Close stores objects fetched lazily but still buffered, and closes packs
+// opened by the repository. The repository can be used again after Close, and
+// packs are reopened on the next access.
+func (r *Repository) Close() error {
+	if err := r.FlushFetchedObjects(); err != nil {
+		return err
+	}
+	if store, ok := r.store.(*fsObjectStore); ok {
+		store.close()
+	}
+	return nil
+}
+
+// HashAlgo returns the hash algorithm of object ids in the repository.
+func (r *Repository) HashAlgo() *HashAlgo {
+	return r.hash
+}
+
+// ObjectStore returns the storage backend of objects.
+func (r *Repository) ObjectStore() ObjectStore {
+	return r.store
+}
+
+// commonDir returns the directory shared among linked worktrees, where objects
+// and most of refs are stored.
+func (r *Repository) commonDir() string {
+	if r.common != "" {
+		return r.common
+	}
+	return r.root
 }
 
 func (r *Repository) Object(id SHA1) (Object, error) {
@@ -90,81 +175,75 @@ func (r *Repository) readObject(id SHA1, obj Object, headerOnly bool) (Object, e
 	return obj, err
 }
 
-func (r *Repository) entry(id SHA1) (objectEntry, error) {
-	if r.packs == nil {
-		if err := r.openPack(); err != nil {
-			return nil, err
+// ForEachObject calls fn for every object in the repository once, whether it's
+// packed or loose. If types are given, only objects of the types are passed to
+// fn. Types are checked by reading only object headers.
+func (r *Repository) ForEachObject(fn func(id SHA1) error, types ...string) error {
+	filter := make(map[string]bool)
+	for _, typ := range types {
+		filter[typ] = true
+	}
+	return r.store.ForEach(func(id SHA1) error {
+		if len(filter) > 0 {
+			typ, _, err := r.Stat(id)
+			if err != nil {
+				return err
+			}
+			if !filter[typ] {
+				return nil
+			}
 		}
-	}
+		return fn(id)
+	})
+}
 
-	for _, pack := range r.packs {
-		if entry, err := pack.entry(id); err == nil {
-			return entry, err
+// Stat returns the type and size of the object like git cat-file -t and -s.
+// Only headers are read if possible. For a deltified object in a pack, the size
+// is taken from the delta header without applying the delta.
+func (r *Repository) Stat(id SHA1) (string, int64, error) {
+	if s, ok := r.store.(objectStatter); ok {
+		typ, size, err := s.Stat(id)
+		if !r.isPromised(err) {
+			return typ, size, err
 		}
 	}
-	return newLooseObjectEntry(r.root, id)
-}
-
-func (r *Repository) openPack() error {
-	pattern := filepath.Join(r.root, "objects", "pack", "pack-*.pack")
-	files, err := filepath.Glob(pattern)
+	entry, err := r.entry(id)
 	if err != nil {
-		return err
+		return "", 0, err
 	}
-	packs := []*Pack{}
-	for _, file := range files {
-		pack, err := OpenPack(file)
-		if err != nil {
-			return err
-		}
-		packs = append(packs, pack)
+	defer entry.Close()
+	data, err := entry.ReadAll()
+	if err != nil {
+		return "", 0, err
 	}
-	r.packs = packs
-	return nil
+	return entry.Type(), int64(len(data)), nil
 }
 
-func (r *Repository) writeObject(typ string, data ObjectData) (id SHA1, err error) {
-	var path string
-	defer func() {
-		if err != nil && path != "" {
-			os.Remove(path)
-		}
-	}()
-	if id, path, err = r.writeObjectData(typ, data); err != nil {
-		return
+// ObjectReader returns a reader of the content of the object. Undeltified
+// objects are inflated on the fly, so that large blobs can be read without
+// holding the whole content in memory.
+func (r *Repository) ObjectReader(id SHA1) (*ObjectReader, error) {
+	entry, err := r.entry(id)
+	if err != nil {
+		return nil, err
 	}
-	err = r.storeAsObject(path, id)
-	return
+	return newObjectReader(entry)
 }
 
-func (r *Repository) writeObjectData(typ string, data ObjectData) (id SHA1, path string, err error) {
-	var f *os.File
-	if f, err = ioutil.TempFile("", "go-git"); err != nil {
-		return
+// entry returns the raw object of id. A missing object in a partial clone is
+// fetched by the registered fetcher.
+func (r *Repository) entry(id SHA1) (ObjectEntry, error) {
+	entry, err := r.store.Entry(id)
+	if r.isPromised(err) {
+		return r.fetchObject(id)
 	}
-	defer f.Close()
-	path = f.Name()
-
-	zw := zlib.NewWriter(f)
-	defer zw.Close()
-	hash := sha1.New()
-	w := io.MultiWriter(hash, zw)
+	return entry, err
+}
 
-	if _, err = fmt.Fprintf(w, "%s %d%c", typ, data.Size(), 0); err != nil {
-		return
-	}
-	if _, err = io.Copy(w, data); err != nil {
-		return
-	}
-	id = SHA1FromBytes(hash.Sum(nil))
-	return
+func (r *Repository) objectsPath() string {
+	return filepath.Join(r.commonDir(), "objects")
 }
 
-func (r *Repository) storeAsObject(path string, id SHA1) error {
-	s := id.String()
-	dir := filepath.Join(r.root, "objects", s[:2])
-	if err := os.MkdirAll(dir, 0777); err != nil {
-		return err
-	}
-	return os.Rename(path, filepath.Join(dir, s[2:]))
+func (r *Repository) writeObject(typ string, data ObjectData) (SHA1, error) {
+	return r.store.Put(typ, data)
 }
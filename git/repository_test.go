@@ -0,0 +1,121 @@
+package git
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mem := NewRepository(NewMemoryObjectStore(nil), nil)
+	ids := newTestObjects(t, mem, 20)
+	type header struct {
+		typ  string
+		size int64
+	}
+	expected := make(map[SHA1]header)
+	for _, id := range ids {
+		typ, size, err := mem.Stat(id)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected[id] = header{typ, size}
+	}
+
+	w := NewPackWriter(mem)
+	w.Add(ids...)
+	path, err := w.Save(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pack, err := OpenPack(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pack.Close()
+	for _, id := range ids {
+		entry := pack.idx.Entry(id)
+		typ, size, err := pack.stat(entry.Offset)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if h := expected[id]; typ != h.typ || size != h.size {
+			t.Errorf("%s: Unexpected header: %s %d, expected %s %d", id, typ, size, h.typ, h.size)
+		}
+	}
+
+	// Make sure that stat has been tested against deltified entries.
+	result, err := pack.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Chains) == 0 {
+		t.Errorf("No deltified entry")
+	}
+	if _, _, err = mem.Stat(SHA1FromBytes([]byte{1})); err != ErrObjectNotFound {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	repo, err := Init(filepath.Join(dir, "repo"), true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := repo.NewBlob(bytes.NewReader([]byte("loose object")))
+	if err = blob.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if typ, size, err := repo.Stat(blob.SHA1()); err != nil || typ != "blob" || size != 12 {
+		t.Errorf("Unexpected header: %s %d %v", typ, size, err)
+	}
+	if _, _, err = repo.Stat(SHA1FromBytes([]byte{1})); err != ErrObjectNotFound {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestForEachObject(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	ids := newTestObjects(t, repo, 10)
+	// Some objects are both packed and loose.
+	w := NewPackWriter(repo)
+	w.Add(ids[5:]...)
+	if _, err := w.Save(filepath.Join(dir, "objects", "pack")); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		types    []string
+		expected int
+	}{
+		{nil, 13},
+		{[]string{"blob"}, 10},
+		{[]string{"tree", "commit"}, 3},
+		{[]string{"tag"}, 0},
+	} {
+		seen := make(map[SHA1]bool)
+		err := repo.ForEachObject(func(id SHA1) error {
+			if seen[id] {
+				t.Errorf("%s: Passed twice", id)
+			}
+			seen[id] = true
+			return nil
+		}, tc.types...)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(seen) != tc.expected {
+			t.Errorf("%v: Unexpected number of objects: %d", tc.types, len(seen))
+		}
+	}
+}
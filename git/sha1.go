@@ -3,47 +3,65 @@ package git
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"io"
 )
 
-type SHA1 [20]byte
+// SHA1 is an object id. Despite its name, it holds a SHA-256 id as well in a
+// repository using the SHA-256 object format. The zero value is the all-zero
+// SHA-1 id.
+type SHA1 struct {
+	hash   [maxHashSize]byte
+	format uint8
+}
 
 var emptySHA1 SHA1
 
+// Algo returns the hash algorithm of the id.
+func (b SHA1) Algo() *HashAlgo {
+	return hashAlgos[b.format]
+}
+
 func (b SHA1) Bytes() []byte {
-	return b[:]
+	return b.hash[:b.Algo().Size]
 }
 
 func (b SHA1) String() string {
-	return hex.EncodeToString(b[:])
+	return hex.EncodeToString(b.Bytes())
 }
 
 func (b SHA1) Compare(other SHA1) int {
-	return bytes.Compare(b[:], other[:])
+	return bytes.Compare(b.hash[:], other.hash[:])
 }
 
+// Fill reads the binary form of the id from r. The length to be read depends on
+// the algorithm of b, which is SHA-1 for the zero value.
 func (b *SHA1) Fill(r io.Reader) error {
-	_, err := io.ReadFull(r, (*b)[:])
+	_, err := io.ReadFull(r, b.hash[:b.Algo().Size])
 	return err
 }
 
 func (b SHA1) Empty() bool {
-	return b == emptySHA1
+	return b.hash == emptySHA1.hash
 }
 
+var ErrInvalidSHA1 = errors.New("Invalid SHA1")
+
+// NewSHA1 parses the full hex form of an id. The hash algorithm is determined
+// by the length. Use Repository.ResolvePrefix to resolve an abbreviated one.
 func NewSHA1(s string) (sha SHA1, err error) {
-	var b []byte
-	if b, err = hex.DecodeString(s); err == nil {
-		copy(sha[:], b)
+	h := hashAlgoBySize(len(s) / 2)
+	if h == nil || len(s)%2 != 0 {
+		err = ErrInvalidSHA1
+		return
 	}
+	sha = h.Zero()
+	_, err = hex.Decode(sha.hash[:], []byte(s))
 	return
 }
 
-func SHA1FromHex(b []byte) (sha SHA1) {
-	if _, err := hex.Decode(sha[:], b); err != nil {
-		panic(err)
-	}
-	return
+func SHA1FromHex(b []byte) SHA1 {
+	return SHA1FromHexString(string(b))
 }
 
 func SHA1FromHexString(s string) SHA1 {
@@ -54,12 +72,11 @@ func SHA1FromHexString(s string) SHA1 {
 	return sha
 }
 
-func SHA1FromBytes(b []byte) (sha SHA1) {
-	copy(sha[:], b)
-	return
-}
-
-func readSHA1(r io.Reader) (sha SHA1, err error) {
-	_, err = io.ReadFull(r, sha[:])
-	return
+// SHA1FromBytes returns the id of binary form b. It's a SHA-256 id if b is 32
+// bytes, otherwise a SHA-1 id.
+func SHA1FromBytes(b []byte) SHA1 {
+	if len(b) == HashSHA256.Size {
+		return HashSHA256.FromBytes(b)
+	}
+	return HashSHA1.FromBytes(b)
 }
@@ -0,0 +1,136 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ShallowCommits returns the commits listed in the shallow file of a
+// repository cloned with limited depth. Their parents are not in the
+// repository, so they are treated as root commits in history traversal.
+func (r *Repository) ShallowCommits() ([]SHA1, error) {
+	shallow, err := r.shallowCommits()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]SHA1, 0, len(shallow))
+	for id := range shallow {
+		ids = append(ids, id)
+	}
+	sort.Sort(bySHA1(ids))
+	return ids, nil
+}
+
+// IsShallow reports whether the repository is shallow.
+func (r *Repository) IsShallow() bool {
+	shallow, _ := r.shallowCommits()
+	return len(shallow) > 0
+}
+
+// SetShallowCommits replaces the shallow file with ids. The file is removed if
+// ids is empty, which makes the repository complete.
+func (r *Repository) SetShallowCommits(ids []SHA1) error {
+	if r.commonDir() == "" {
+		return ErrNotSupported
+	}
+	path := r.shallowPath()
+	shallow := make(map[SHA1]bool, len(ids))
+	for _, id := range ids {
+		shallow[id] = true
+	}
+	if len(shallow) == 0 {
+		if err := removeIfExists(path); err != nil {
+			return err
+		}
+		r.shallow, r.shallowErr = shallow, nil
+		return nil
+	}
+
+	sorted := make([]SHA1, 0, len(shallow))
+	for id := range shallow {
+		sorted = append(sorted, id)
+	}
+	sort.Sort(bySHA1(sorted))
+	buf := new(bytes.Buffer)
+	for _, id := range sorted {
+		fmt.Fprintf(buf, "%s\n", id)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "tmp_shallow_")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	r.shallow, r.shallowErr = shallow, nil
+	return nil
+}
+
+// isShallowCommit reports whether the parents of the commit must be ignored.
+func (r *Repository) isShallowCommit(id SHA1) bool {
+	shallow, _ := r.shallowCommits()
+	return shallow[id]
+}
+
+// shallowCommits loads the shallow file. The result including an error is
+// cached.
+func (r *Repository) shallowCommits() (map[SHA1]bool, error) {
+	if r.shallow == nil && r.shallowErr == nil {
+		r.shallow, r.shallowErr = readShallow(r.shallowPath())
+	}
+	return r.shallow, r.shallowErr
+}
+
+func (r *Repository) shallowPath() string {
+	if r.commonDir() == "" {
+		return ""
+	}
+	return filepath.Join(r.commonDir(), "shallow")
+}
+
+// readShallow reads the shallow file which lists a commit id per line. An
+// empty set is returned if the file doesn't exist.
+func readShallow(path string) (map[SHA1]bool, error) {
+	shallow := make(map[SHA1]bool)
+	if path == "" {
+		return shallow, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return shallow, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scan := bufio.NewScanner(f)
+	for scan.Scan() {
+		line := bytes.TrimSpace(scan.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		id, ok := parseHexID(string(line))
+		if !ok {
+			return nil, fmt.Errorf("Invalid shallow commit: %s", line)
+		}
+		shallow[id] = true
+	}
+	if err = scan.Err(); err != nil {
+		return nil, err
+	}
+	return shallow, nil
+}
@@ -0,0 +1,93 @@
+package git
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShallow(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	if repo.IsShallow() {
+		t.Fatalf("Unexpected shallow repository")
+	}
+
+	// The history is cut at shallow, whose parent is missing.
+	blob := repo.NewBlob(bytes.NewReader([]byte("hello\n")))
+	tree := repo.NewTree()
+	tree.Add("hello.txt", blob, ModeFile)
+	if err := tree.Write(); err != nil {
+		t.Fatal(err)
+	}
+	user := NewUser("go-git", "go-git@example.com")
+	missing := newCommit(SHA1FromBytes([]byte{1}), repo)
+	shallow := repo.NewCommit(tree, []*Commit{missing}, user, user, "shallow\n")
+	if err := shallow.Write(); err != nil {
+		t.Fatal(err)
+	}
+	head := repo.NewCommit(tree, []*Commit{shallow}, user, user, "head\n")
+	if err := head.Write(); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.NewRef(BranchRef("master"), head.SHA1()).Write(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ReachableObjects([]SHA1{head.SHA1()}); err == nil {
+		t.Fatalf("Expected error for the missing parent")
+	}
+
+	if err := repo.SetShallowCommits([]SHA1{shallow.SHA1()}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, err := repo.ShallowCommits()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !repo.IsShallow() || len(ids) != 1 || ids[0] != shallow.SHA1() {
+		t.Fatalf("Unexpected shallow commits: %v", ids)
+	}
+	c, err := repo.Commit(shallow.SHA1())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsShallow() {
+		t.Errorf("%s is not shallow", c.SHA1())
+	}
+	if _, parents, err := repo.commitLinks(shallow.SHA1()); err != nil || len(parents) != 0 {
+		t.Errorf("Unexpected parents: %v, %v", parents, err)
+	}
+
+	objs, err := repo.ReachableObjects([]SHA1{head.SHA1()})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// 2 commits, the tree and the blob.
+	if len(objs) != 4 {
+		t.Errorf("Unexpected number of objects: %d", len(objs))
+	}
+	result, err := repo.Fsck()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.OK() {
+		t.Errorf("Unexpected fsck result: %+v", result)
+	}
+	if err = repo.WriteCommitGraph([]SHA1{head.SHA1()}); err != ErrNotSupported {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err = repo.SetShallowCommits(nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "shallow")); !os.IsNotExist(err) {
+		t.Errorf("shallow file is not removed: %v", err)
+	}
+	if repo.IsShallow() {
+		t.Errorf("Unexpected shallow repository")
+	}
+}
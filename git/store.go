@@ -0,0 +1,329 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// bigObjectSize is the size of objects above which Put doesn't hold them in
+// memory, same as the default of core.bigFileThreshold.
+const bigObjectSize = 512 << 20
+
+// packRescanInterval is the minimum interval between rescans of pack
+// directories triggered by lookup misses.
+var packRescanInterval = time.Second
+
+// ObjectStore is a storage backend of objects. Repository reads and writes all
+// objects through it.
+type ObjectStore interface {
+	// Entry returns the raw object of id. ErrObjectNotFound is returned if
+	// the store doesn't have it.
+	Entry(id SHA1) (ObjectEntry, error)
+	// Has reports whether the store has the object of id.
+	Has(id SHA1) bool
+	// Put stores an object of typ and returns its id.
+	Put(typ string, data ObjectData) (SHA1, error)
+	// ForEach calls fn for every object in the store once. If fn returns an
+	// error, the iteration stops and the error is returned.
+	ForEach(fn func(id SHA1) error) error
+}
+
+// objectStatter is implemented by an ObjectStore which can tell the type and
+// size of an object without reading its content.
+type objectStatter interface {
+	Stat(id SHA1) (string, int64, error)
+}
+
+// fsObjectStore is the default ObjectStore which stores objects in the
+// objects directory of a repository as loose objects and packs. It's not safe
+// for concurrent use, because packs are reopened by a rescan during lookups.
+// Entries already returned stay readable until they're closed even if their
+// packs are dropped by a rescan.
+type fsObjectStore struct {
+	path string
+	hash *HashAlgo
+	dirs []*objectDir
+
+	// alternates are object directories looked up after path and its own
+	// alternates.
+	alternates []string
+
+	// compression is the zlib level of loose objects.
+	compression int
+	// fsync makes loose objects durable before they're renamed into place.
+	fsync bool
+
+	// lastScan is when packs were scanned last time.
+	lastScan time.Time
+}
+
+func newFSObjectStore(path string, hash *HashAlgo) *fsObjectStore {
+	return &fsObjectStore{path: path, hash: hash, compression: zlib.BestSpeed}
+}
+
+// configure applies the settings of loose objects in config. The compression
+// level is core.looseCompression, or core.compression if it's not set, and
+// defaults to the best speed same as git.
+func (s *fsObjectStore) configure(config *Config) error {
+	level, err := config.Int("core.compression", zlib.BestSpeed)
+	if err != nil {
+		return err
+	}
+	if level, err = config.Int("core.looseCompression", level); err != nil {
+		return err
+	}
+	if level < zlib.DefaultCompression || level > zlib.BestCompression {
+		return fmt.Errorf("Bad zlib compression level %d", level)
+	}
+	s.compression = int(level)
+	s.fsync, err = config.Bool("core.fsyncObjectFiles", false)
+	return err
+}
+
+// open opens the object directory followed by its alternates lazily.
+func (s *fsObjectStore) open() error {
+	if s.dirs != nil {
+		return nil
+	}
+	dirs, err := openObjectDirs(s.path, s.hash)
+	if err != nil {
+		return err
+	}
+	for _, path := range s.alternates {
+		alt, err := openObjectDirs(path, s.hash)
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, alt...)
+	}
+	s.dirs = dirs
+	s.lastScan = time.Now()
+	return nil
+}
+
+// rescan picks up packs added and drops packs removed since the last scan, so
+// objects repacked by others after opening can be found. It does nothing and
+// returns false if the last scan was done within packRescanInterval. If it
+// fails, the time of the last scan is left unchanged so that the next lookup
+// miss tries again.
+func (s *fsObjectStore) rescan() (bool, error) {
+	if time.Since(s.lastScan) < packRescanInterval {
+		return false, nil
+	}
+	for _, dir := range s.dirs {
+		if err := dir.openPack(); err != nil {
+			return false, err
+		}
+	}
+	s.lastScan = time.Now()
+	return true, nil
+}
+
+// lookup calls fn for each directory until fn returns other than
+// ErrObjectNotFound. If no directory has the object, it tries again once
+// after rescanning packs.
+func (s *fsObjectStore) lookup(fn func(dir *objectDir) error) error {
+	if err := s.open(); err != nil {
+		return err
+	}
+	for retried := false; ; retried = true {
+		for _, dir := range s.dirs {
+			if err := fn(dir); err != ErrObjectNotFound {
+				return err
+			}
+		}
+		if retried {
+			return ErrObjectNotFound
+		}
+		if ok, err := s.rescan(); err != nil {
+			return err
+		} else if !ok {
+			return ErrObjectNotFound
+		}
+	}
+}
+
+// close closes all packs. The directories are opened again on the next access.
+func (s *fsObjectStore) close() {
+	for _, dir := range s.dirs {
+		for _, pack := range dir.packs {
+			pack.Close()
+		}
+	}
+	s.dirs = nil
+}
+
+func (s *fsObjectStore) Entry(id SHA1) (entry ObjectEntry, err error) {
+	err = s.lookup(func(dir *objectDir) (err error) {
+		entry, err = dir.entry(id)
+		return
+	})
+	return
+}
+
+func (s *fsObjectStore) Stat(id SHA1) (typ string, size int64, err error) {
+	err = s.lookup(func(dir *objectDir) (err error) {
+		typ, size, err = dir.stat(id)
+		return
+	})
+	return
+}
+
+func (s *fsObjectStore) Has(id SHA1) bool {
+	err := s.lookup(func(dir *objectDir) error {
+		if dir.has(id) {
+			return nil
+		}
+		return ErrObjectNotFound
+	})
+	return err == nil
+}
+
+func (s *fsObjectStore) ForEach(fn func(id SHA1) error) error {
+	if err := s.open(); err != nil {
+		return err
+	}
+	seen := make(map[SHA1]bool)
+	for _, dir := range s.dirs {
+		err := dir.forEach(func(id SHA1) error {
+			if seen[id] {
+				return nil
+			}
+			seen[id] = true
+			return fn(id)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Put writes data as a loose object. If the object already exists, nothing is
+// written but the modification time of the loose object or the pack which
+// contains it is updated so that it isn't pruned as unreachable. Objects up to
+// bigObjectSize are hashed before writing, and larger ones are hashed while
+// they're written into a temporary file.
+func (s *fsObjectStore) Put(typ string, data ObjectData) (id SHA1, err error) {
+	if data.Size() <= bigObjectSize {
+		var b []byte
+		if b, err = ioutil.ReadAll(data); err != nil {
+			return
+		}
+		if int64(len(b)) != data.Size() {
+			return id, ErrUnknownFormat
+		}
+		if id = s.hash.hashObject(typ, b); s.freshen(id) {
+			return
+		}
+		data = bytes.NewReader(b)
+	}
+
+	var path string
+	defer func() {
+		if err != nil && path != "" {
+			os.Remove(path)
+		}
+	}()
+	if id, path, err = s.writeObjectData(typ, data); err != nil {
+		return
+	}
+	if s.freshen(id) {
+		err = os.Remove(path)
+		return
+	}
+	err = s.storeAsObject(path, id)
+	return
+}
+
+// writeObjectData writes data into a read-only temporary file in the objects
+// directory. The file is created there, not in the system temp directory, so
+// that it can be renamed into place without crossing filesystems.
+func (s *fsObjectStore) writeObjectData(typ string, data ObjectData) (id SHA1, path string, err error) {
+	var f *os.File
+	if f, err = ioutil.TempFile(s.path, "tmp_obj_"); err != nil {
+		return
+	}
+	path = f.Name()
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Chmod(path, 0444)
+		}
+	}()
+
+	zw, err := zlib.NewWriterLevel(f, s.compression)
+	if err != nil {
+		return
+	}
+	hash := s.hash.New()
+	w := io.MultiWriter(hash, zw)
+
+	if _, err = fmt.Fprintf(w, "%s %d%c", typ, data.Size(), 0); err != nil {
+		return
+	}
+	if _, err = io.Copy(w, data); err != nil {
+		return
+	}
+	if err = zw.Close(); err != nil {
+		return
+	}
+	if s.fsync {
+		if err = f.Sync(); err != nil {
+			return
+		}
+	}
+	id = s.hash.Sum(hash)
+	return
+}
+
+func (s *fsObjectStore) storeAsObject(path string, id SHA1) error {
+	h := id.String()
+	dir := filepath.Join(s.path, h[:2])
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	return os.Rename(path, filepath.Join(dir, h[2:]))
+}
+
+// freshen reports whether the object of id already exists, and sets the
+// modification time of the loose object or the pack which contains it to now.
+// Like git, the object is treated as missing if the time can't be updated,
+// e.g. it's in a read-only alternate, so that it's written again.
+func (s *fsObjectStore) freshen(id SHA1) bool {
+	h := id.String()
+	now := time.Now()
+	if os.Chtimes(filepath.Join(s.path, h[:2], h[2:]), now, now) == nil {
+		return true
+	}
+	var fresh bool
+	s.lookup(func(dir *objectDir) error {
+		path := filepath.Join(dir.path, h[:2], h[2:])
+		if pack := dir.packOf(id); pack != nil {
+			path = pack.path
+		} else if _, err := os.Stat(path); err != nil {
+			return ErrObjectNotFound
+		}
+		fresh = os.Chtimes(path, now, now) == nil
+		return nil
+	})
+	return fresh
+}
+
+// parseLooseObjectPath returns the object id of a loose object file whose path
+// is dir/name relative to the objects directory.
+func parseLooseObjectPath(dir, name string) (id SHA1, ok bool) {
+	if len(dir) != 2 {
+		return
+	}
+	id, err := NewSHA1(dir + name)
+	return id, err == nil
+}
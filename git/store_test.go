@@ -0,0 +1,122 @@
+package git
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPutLooseObject(t *testing.T) {
+	_, dir := newTestRepo(t, true)
+	config := "[core]\n\tcompression = 0\n\tlooseCompression = 9\n\tfsyncObjectFiles\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "config"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store := repo.store.(*fsObjectStore)
+	if store.compression != zlib.BestCompression || !store.fsync {
+		t.Fatalf("Unexpected settings: %d, %v", store.compression, store.fsync)
+	}
+
+	data := []byte(strings.Repeat("hello\n", 100))
+	id, err := repo.writeObject("blob", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	h := id.String()
+	path := filepath.Join(dir, "objects", h[:2], h[2:])
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fi.Mode().Perm() != 0444 {
+		t.Errorf("Unexpected permission: %v", fi.Mode())
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, "objects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if !f.IsDir() {
+			t.Errorf("Temporary file is left: %s", f.Name())
+		}
+	}
+	b, err := repo.Blob(id)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(b.Data, data) {
+		t.Errorf("Unexpected data: %q", b.Data)
+	}
+
+	// Writing the same object again only freshens it.
+	past := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(path, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repo.writeObject("blob", bytes.NewReader(data)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fi, err = os.Stat(path); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !fi.ModTime().After(past) {
+		t.Errorf("Object is not freshened: %v", fi.ModTime())
+	}
+
+	config = "[core]\n\tlooseCompression = 10\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, "config"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(dir); err == nil {
+		t.Errorf("Expected error for a bad compression level")
+	}
+}
+
+func TestPutPackedObject(t *testing.T) {
+	repo, dir := newTestRepo(t, true)
+	mem := NewRepository(NewMemoryObjectStore(nil), nil)
+	ids := newTestObjects(t, mem, 1)
+	w := NewPackWriter(mem)
+	w.Add(ids...)
+	path, err := w.Save(filepath.Join(dir, "objects", "pack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err = os.Chtimes(path, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writing a packed object freshens the pack instead of writing it.
+	blob, err := mem.Blob(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := repo.writeObject("blob", bytes.NewReader(blob.Data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if id != ids[0] {
+		t.Fatalf("Unexpected id: %s", id)
+	}
+	h := id.String()
+	if _, err = os.Stat(filepath.Join(dir, "objects", h[:2], h[2:])); !os.IsNotExist(err) {
+		t.Errorf("Loose object is written: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().After(past) {
+		t.Errorf("Pack is not freshened: %v", fi.ModTime())
+	}
+}
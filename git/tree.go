@@ -31,16 +31,17 @@ func (t *Tree) SHA1() SHA1 {
 func (t *Tree) Parse(data []byte) error {
 	var mode, name, id, rest []byte
 	var pos int
+	size := t.repo.hash.Size
 	for len(data) > 0 {
 		if pos = bytes.IndexByte(data, ' '); pos == -1 {
 			return ErrUnknownFormat
 		}
 		mode, rest = data[:pos], data[pos+1:]
 
-		if pos = bytes.IndexByte(rest, 0); pos == -1 {
+		if pos = bytes.IndexByte(rest, 0); pos == -1 || len(rest) < pos+1+size {
 			return ErrUnknownFormat
 		}
-		name, id, rest = rest[:pos], rest[pos+1:pos+21], rest[pos+21:]
+		name, id, rest = rest[:pos], rest[pos+1:pos+1+size], rest[pos+1+size:]
 
 		entry, err := newTreeEntry(mode, name, id, t.repo)
 		if err != nil {
@@ -109,7 +110,7 @@ func (t *Tree) fastFind(parts []string) (*SparseObject, TreeEntryMode, error) {
 		if err != nil {
 			return nil, 0, err
 		}
-		id, mode, err = findTreeEntryBytes(data, name)
+		id, mode, err = findTreeEntryBytes(data, name, t.repo.hash)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -117,7 +118,7 @@ func (t *Tree) fastFind(parts []string) (*SparseObject, TreeEntryMode, error) {
 	return newSparseObject(id, t.repo), mode, nil
 }
 
-func findTreeEntryBytes(data []byte, name string) (id SHA1, mode TreeEntryMode, err error) {
+func findTreeEntryBytes(data []byte, name string, h *HashAlgo) (id SHA1, mode TreeEntryMode, err error) {
 	scratchbuf.Reset()
 	scratchbuf.WriteString(name)
 	scratchbuf.WriteByte(0)
@@ -141,13 +142,16 @@ func findTreeEntryBytes(data []byte, name string) (id SHA1, mode TreeEntryMode,
 			break
 		}
 		if data[n-1] == 0 && bytes.Equal(data[:n], term) {
-			return SHA1FromBytes(data[n : n+20]), mode, nil
+			if len(data) < n+h.Size {
+				return id, 0, ErrUnknownFormat
+			}
+			return h.FromBytes(data[n : n+h.Size]), mode, nil
 		}
 		i = bytes.IndexByte(data, 0)
-		if i < 0 {
+		if i < 0 || len(data) < i+1+h.Size {
 			return id, 0, ErrUnknownFormat
 		}
-		data = data[i+21:]
+		data = data[i+1+h.Size:]
 	}
 	return id, 0, ErrObjectNotFound
 }
@@ -313,7 +317,7 @@ func newTreeEntry(mode, name, id []byte, repo *Repository) (*TreeEntry, error) {
 	entry := &TreeEntry{
 		Mode:   m,
 		Name:   string(name),
-		Object: newSparseObject(SHA1FromBytes(id), repo),
+		Object: newSparseObject(repo.hash.FromBytes(id), repo),
 	}
 	return entry, nil
 }
@@ -336,6 +340,7 @@ const (
 	ModeFile    TreeEntryMode = 0100644
 	ModeFileEx  TreeEntryMode = 0100755
 	ModeSymlink TreeEntryMode = 0120000
+	ModeGitlink TreeEntryMode = 0160000
 )
 
 func parseMode(bs []byte) (TreeEntryMode, error) {
@@ -65,6 +65,24 @@ func (c *Cache) Get(key interface{}) (value interface{}, ok bool) {
 	return
 }
 
+// RemoveFunc removes all entries for which fn returns true. The eviction
+// callback is called for each removed entry.
+func (c *Cache) RemoveFunc(fn func(key, value interface{}) bool) {
+	for e := c.ll.Front(); e != nil; {
+		next := e.Next()
+		ent := e.Value.(*entry)
+		if fn(ent.key, ent.value) {
+			c.ll.Remove(e)
+			delete(c.items, ent.key)
+			c.size -= ent.size
+			if c.onEvicted != nil {
+				c.onEvicted(ent.key, ent.value)
+			}
+		}
+		e = next
+	}
+}
+
 func (c *Cache) Size() int {
 	return c.size
 }
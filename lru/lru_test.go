@@ -81,3 +81,27 @@ func TestLRUBySize(t *testing.T) {
 		t.Fatalf("Invalid cache: evicted %d, size %d, len %d", evictedSize, n, l)
 	}
 }
+
+func TestLRURemoveFunc(t *testing.T) {
+	var evicted []interface{}
+	cache := NewWithEvict(10, func(key, value interface{}) {
+		evicted = append(evicted, key)
+	})
+	for i := 1; i <= 4; i++ {
+		cache.Add(i, sizedItem(i))
+	}
+	cache.RemoveFunc(func(key, value interface{}) bool {
+		return key.(int)%2 == 0
+	})
+	if len(evicted) != 2 {
+		t.Fatalf("Unexpected eviction: %v", evicted)
+	}
+	if n, l := cache.Size(), cache.Len(); n != 4 || l != 2 {
+		t.Fatalf("Invalid cache: size %d, len %d", n, l)
+	}
+	for i := 1; i <= 4; i++ {
+		if _, ok := cache.Get(i); ok != (i%2 == 1) {
+			t.Errorf("%d: Unexpected existence: %v", i, ok)
+		}
+	}
+}